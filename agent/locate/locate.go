@@ -91,6 +91,12 @@ func LookupPathEnv(key, fallback string, shortPath bool) string {
 	return expandPath(unexpanded, shortPath)
 }
 
+// ExpandPath replaces a placeholder prefix (like %workspace% or ~) of the input with the concrete path.
+// Inputs without a known prefix are returned unchanged.
+func ExpandPath(input string) string {
+	return expandPath(input, false)
+}
+
 func Workdir() string {
 	return os.Getenv(api.WorkdirEnv)
 }
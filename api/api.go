@@ -59,6 +59,22 @@ type CacheKeyer interface {
 	CacheKey(GetCredentialsRequest) string
 }
 
+// ContextCacheKeyer is an optional interface that can be implemented by helpers
+// whose cache key depends on the helper configuration stored in the context.
+// If implemented, it is used instead of CacheKeyer.
+type ContextCacheKeyer interface {
+	CacheKeyWithContext(context.Context, GetCredentialsRequest) string
+}
+
+// CacheKeyFor returns the cache key of the helper for the request.
+// It prefers ContextCacheKeyer over CacheKeyer.
+func CacheKeyFor(ctx context.Context, helper Helper, req GetCredentialsRequest) string {
+	if contextCacheKeyer, ok := helper.(ContextCacheKeyer); ok {
+		return contextCacheKeyer.CacheKeyWithContext(ctx, req)
+	}
+	return helper.CacheKey(req)
+}
+
 // Helper is the interface that must be implemented by credential helpers
 type Helper interface {
 	Resolver(context.Context) (Resolver, error)
@@ -90,6 +106,22 @@ const (
 	PruneIntervalEnv    = "CREDENTIAL_HELPER_PRUNE_INTERVAL"
 	GuessOCIRegistryEnv = "CREDENTIAL_HELPER_GUESS_OCI_REGISTRY"
 	ConfigFileEnv       = "CREDENTIAL_HELPER_CONFIG_FILE"
+	ProfileEnv          = "CREDENTIAL_HELPER_PROFILE"
+	ProfileFileEnv      = "CREDENTIAL_HELPER_PROFILE_FILE"
+	// Settings of the encrypted file secret store.
+	SecretStoreFileEnv       = "CREDENTIAL_HELPER_SECRET_STORE_FILE"
+	SecretStoreKeyFileEnv    = "CREDENTIAL_HELPER_SECRET_STORE_KEY_FILE"
+	SecretStorePassphraseEnv = "CREDENTIAL_HELPER_SECRET_STORE_PASSPHRASE"
+	// BundleIdentityEnv holds the identity used to decrypt encrypted bundles.
+	BundleIdentityEnv = "CREDENTIAL_HELPER_BUNDLE_IDENTITY"
+	// The name of the detected CI provider.
+	// It is set by the helper whenever a CI provider is detected, even if the CI preset is disabled.
+	CIProviderEnv = "CREDENTIAL_HELPER_CI_PROVIDER"
+	// CIPresetEnv is set to "1" by the helper if the CI preset is active.
+	CIPresetEnv = "CREDENTIAL_HELPER_CI_PRESET"
+	// The uri passed to the helper.
+	// It is set by the helper before choosing a helper, so that lookup chain sources can depend on it.
+	RequestURIEnv = "CREDENTIAL_HELPER_REQUEST_URI"
 	// The working directory for the agent and client process.
 	// On startup, we chdir into it.
 	WorkdirEnv = "CREDENTIAL_HELPER_WORKDIR"
@@ -0,0 +1,238 @@
+package composite
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tweag/credential-helper/api"
+	"github.com/tweag/credential-helper/authenticate/internal/helperconfig"
+	"github.com/tweag/credential-helper/logging"
+)
+
+const (
+	// ModeFirstSuccess tries the child helpers in order and returns the first successful response.
+	ModeFirstSuccess = "first_success"
+	// ModeMergeHeaders queries all child helpers and merges the headers of their responses.
+	ModeMergeHeaders = "merge_headers"
+)
+
+// Composite is a credential helper that combines other helpers.
+// The child helpers are looked up by name, so they can be configured just like top-level helpers.
+type Composite struct {
+	helperFromString func(string) api.Helper
+}
+
+// New returns a composite helper that looks up child helpers using helperFromString.
+func New(helperFromString func(string) api.Helper) *Composite {
+	return &Composite{helperFromString: helperFromString}
+}
+
+func (c *Composite) Resolver(ctx context.Context) (api.Resolver, error) {
+	cfg, children, err := c.children(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &CompositeResolver{mode: cfg.Mode, children: children}, nil
+}
+
+// CacheKey returns a cache key for the given request.
+// The composite helper derives its cache key from its children, which requires the helper config.
+// Without it, no cache key is returned (do not cache).
+func (c *Composite) CacheKey(req api.GetCredentialsRequest) string {
+	return ""
+}
+
+// CacheKeyWithContext derives a cache key from the cache keys of the child helpers.
+// In first_success mode, the response may come from any child, so caching is only disabled if no child can be cached.
+// In merge_headers mode, every child contributes to the response, so caching is disabled if any child cannot be cached.
+func (c *Composite) CacheKeyWithContext(ctx context.Context, req api.GetCredentialsRequest) string {
+	cfg, children, err := c.children(ctx)
+	if err != nil {
+		logging.Errorf("composite helper: %v", err)
+		return ""
+	}
+	var keys []string
+	var cachable int
+	for _, child := range children {
+		key := api.CacheKeyFor(child.ctx, child.helper, req)
+		if len(key) > 0 {
+			cachable++
+		}
+		keys = append(keys, strconv.Quote(child.name+":"+key))
+	}
+	if cachable == 0 || (cfg.Mode == ModeMergeHeaders && cachable < len(children)) {
+		return ""
+	}
+	return fmt.Sprintf("composite+%s:%s", cfg.Mode, strings.Join(keys, ","))
+}
+
+func (c *Composite) SetupInstructionsForURI(ctx context.Context, uri string) string {
+	cfg, children, err := c.children(ctx)
+	if err != nil {
+		return fmt.Sprintf("%s uses a composite helper, but due to a configuration parsing issue, no further setup instructions are available: %v", uri, err)
+	}
+
+	var description string
+	switch cfg.Mode {
+	case ModeFirstSuccess:
+		description = "tries the following helpers in order and uses the first one that succeeds"
+	case ModeMergeHeaders:
+		description = "queries all of the following helpers and combines their headers"
+	}
+
+	instructions := []string{fmt.Sprintf("%s uses a composite helper that %s.", uri, description)}
+	for i, child := range children {
+		instruction := fmt.Sprintf("[%d/%d] %s:\n\n%s", i+1, len(children), child.name, child.setupInstructions(uri))
+		instructions = append(instructions, instruction)
+	}
+	return strings.Join(instructions, "\n\n")
+}
+
+type CompositeResolver struct {
+	mode     string
+	children []child
+}
+
+// Get implements the get command of the credential-helper spec:
+//
+// https://github.com/EngFlow/credential-helper-spec/blob/main/spec.md#get
+func (c *CompositeResolver) Get(ctx context.Context, req api.GetCredentialsRequest) (api.GetCredentialsResponse, error) {
+	switch c.mode {
+	case ModeMergeHeaders:
+		return c.mergeHeaders(req)
+	default:
+		return c.firstSuccess(req)
+	}
+}
+
+func (c *CompositeResolver) firstSuccess(req api.GetCredentialsRequest) (api.GetCredentialsResponse, error) {
+	var errs []error
+	for _, child := range c.children {
+		resp, err := child.get(req)
+		if err == nil {
+			logging.Debugf("composite helper: using response of %s", child.name)
+			return resp, nil
+		}
+		logging.Debugf("composite helper: %s failed - trying next helper: %v", child.name, err)
+		errs = append(errs, fmt.Errorf("%s: %w", child.name, err))
+	}
+	return api.GetCredentialsResponse{}, fmt.Errorf("all helpers failed: %w", errors.Join(errs...))
+}
+
+func (c *CompositeResolver) mergeHeaders(req api.GetCredentialsRequest) (api.GetCredentialsResponse, error) {
+	merged := api.GetCredentialsResponse{Headers: make(map[string][]string)}
+	var expires time.Time
+	cachable := true
+	for _, child := range c.children {
+		resp, err := child.get(req)
+		if err != nil {
+			return api.GetCredentialsResponse{}, fmt.Errorf("%s: %w", child.name, err)
+		}
+		for name, values := range resp.Headers {
+			merged.Headers[name] = append(merged.Headers[name], values...)
+		}
+		// the merged response expires as soon as the first child response expires
+		if len(resp.Expires) == 0 {
+			cachable = false
+			continue
+		}
+		childExpires, err := time.Parse(time.RFC3339, resp.Expires)
+		if err != nil {
+			return api.GetCredentialsResponse{}, fmt.Errorf("%s: parsing expiration time: %w", child.name, err)
+		}
+		if expires.IsZero() || childExpires.Before(expires) {
+			expires = childExpires
+		}
+	}
+	if cachable && !expires.IsZero() {
+		merged.Expires = expires.UTC().Format(time.RFC3339)
+	}
+	return merged, nil
+}
+
+type child struct {
+	name   string
+	helper api.Helper
+	// ctx holds the helper config of the child
+	ctx context.Context
+}
+
+func (c child) get(req api.GetCredentialsRequest) (api.GetCredentialsResponse, error) {
+	resolver, err := c.helper.Resolver(c.ctx)
+	if err != nil {
+		return api.GetCredentialsResponse{}, fmt.Errorf("instantiating resolver: %w", err)
+	}
+	return resolver.Get(c.ctx, req)
+}
+
+func (c child) setupInstructions(uri string) string {
+	if setupper, ok := c.helper.(api.URISetupper); ok {
+		return setupper.SetupInstructionsForURI(c.ctx, uri)
+	}
+	resolver, err := c.helper.Resolver(c.ctx)
+	if err != nil {
+		return fmt.Sprintf("instantiating resolver: %v", err)
+	}
+	if setupper, ok := resolver.(api.URISetupper); ok {
+		return setupper.SetupInstructionsForURI(c.ctx, uri)
+	}
+	return "No setup instructions available."
+}
+
+func (c *Composite) children(ctx context.Context) (configFragment, []child, error) {
+	cfg, err := configFromContext(ctx)
+	if err != nil {
+		return cfg, nil, err
+	}
+	switch cfg.Mode {
+	case ModeFirstSuccess, ModeMergeHeaders:
+	default:
+		return cfg, nil, fmt.Errorf(`unknown mode %q. Possible values are "first_success" and "merge_headers"`, cfg.Mode)
+	}
+	if len(cfg.Helpers) == 0 {
+		return cfg, nil, errors.New("no helpers configured")
+	}
+	children := make([]child, len(cfg.Helpers))
+	for i, childCfg := range cfg.Helpers {
+		helper := c.helperFromString(childCfg.Helper)
+		if helper == nil {
+			return cfg, nil, fmt.Errorf("helpers[%d]: unknown helper: %s", i, childCfg.Helper)
+		}
+		// replace the config of the composite helper with the config of the child
+		var childHelperConfig any
+		if len(childCfg.Config) > 0 {
+			childHelperConfig = []byte(childCfg.Config)
+		}
+		children[i] = child{
+			name:   childCfg.Helper,
+			helper: helper,
+			ctx:    context.WithValue(ctx, api.HelperConfigKey, childHelperConfig),
+		}
+	}
+	return cfg, children, nil
+}
+
+type childConfig struct {
+	// Helper is the name of a registered helper.
+	Helper string `json:"helper"`
+	// Config is the helper-specific configuration of the child.
+	Config json.RawMessage `json:"config,omitempty"`
+}
+
+type configFragment struct {
+	// Mode is either "first_success" (default) or "merge_headers".
+	Mode string `json:"mode"`
+	// Helpers are the child helpers.
+	Helpers []childConfig `json:"helpers"`
+}
+
+func configFromContext(ctx context.Context) (configFragment, error) {
+	return helperconfig.FromContext(ctx, configFragment{
+		Mode: ModeFirstSuccess,
+	})
+}
@@ -0,0 +1,114 @@
+package composite
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tweag/credential-helper/api"
+)
+
+// fakeHelper returns a fixed response or error.
+type fakeHelper struct {
+	resp api.GetCredentialsResponse
+	err  error
+	// calls counts the requests to the helper
+	calls int
+}
+
+func (f *fakeHelper) Resolver(context.Context) (api.Resolver, error) {
+	return f, nil
+}
+
+func (f *fakeHelper) CacheKey(req api.GetCredentialsRequest) string {
+	return req.URI
+}
+
+func (f *fakeHelper) Get(context.Context, api.GetCredentialsRequest) (api.GetCredentialsResponse, error) {
+	f.calls++
+	return f.resp, f.err
+}
+
+func resolve(t *testing.T, helpers map[string]api.Helper, config string) (api.GetCredentialsResponse, error) {
+	t.Helper()
+	ctx := context.WithValue(context.Background(), api.HelperConfigKey, []byte(config))
+	resolver, err := New(func(name string) api.Helper { return helpers[name] }).Resolver(ctx)
+	assert.NoError(t, err)
+	return resolver.Get(ctx, api.GetCredentialsRequest{URI: "https://example.com/foo"})
+}
+
+func TestMergeHeaders(t *testing.T) {
+	now := time.Now().UTC()
+	first := &fakeHelper{resp: api.GetCredentialsResponse{
+		Headers: map[string][]string{"Authorization": {"Bearer first"}, "X-First": {"1"}},
+		Expires: now.Add(2 * time.Hour).Format(time.RFC3339),
+	}}
+	second := &fakeHelper{resp: api.GetCredentialsResponse{
+		Headers: map[string][]string{"Authorization": {"Bearer second"}, "X-Second": {"2"}},
+		Expires: now.Add(time.Hour).Format(time.RFC3339),
+	}}
+	helpers := map[string]api.Helper{"first": first, "second": second}
+	config := `{"mode": "merge_headers", "helpers": [{"helper": "first"}, {"helper": "second"}]}`
+
+	resp, err := resolve(t, helpers, config)
+	assert.NoError(t, err)
+	// values of conflicting headers are kept in the order of the helpers
+	assert.Equal(t, map[string][]string{
+		"Authorization": {"Bearer first", "Bearer second"},
+		"X-First":       {"1"},
+		"X-Second":      {"2"},
+	}, resp.Headers)
+	// the merged response expires with the first child response
+	assert.Equal(t, now.Add(time.Hour).Format(time.RFC3339), resp.Expires)
+
+	// a child response without expiry makes the merged response uncachable
+	second.resp.Expires = ""
+	resp, err = resolve(t, helpers, config)
+	assert.NoError(t, err)
+	assert.Empty(t, resp.Expires)
+
+	// every child must succeed
+	second.err = errors.New("no token")
+	_, err = resolve(t, helpers, config)
+	assert.ErrorContains(t, err, "second: no token")
+}
+
+func TestFirstSuccess(t *testing.T) {
+	failing := &fakeHelper{err: errors.New("no token")}
+	working := &fakeHelper{resp: api.GetCredentialsResponse{Headers: map[string][]string{"Authorization": {"Bearer working"}}}}
+	unused := &fakeHelper{}
+	helpers := map[string]api.Helper{"failing": failing, "working": working, "unused": unused}
+
+	resp, err := resolve(t, helpers, `{"helpers": [{"helper": "failing"}, {"helper": "working"}, {"helper": "unused"}]}`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Bearer working"}, resp.Headers["Authorization"])
+	assert.Equal(t, 1, failing.calls)
+	assert.Equal(t, 0, unused.calls)
+
+	_, err = resolve(t, helpers, `{"helpers": [{"helper": "failing"}, {"helper": "failing"}]}`)
+	assert.ErrorContains(t, err, "all helpers failed")
+	assert.ErrorContains(t, err, "failing: no token")
+}
+
+func TestCacheKey(t *testing.T) {
+	cachable := &fakeHelper{}
+	helpers := map[string]api.Helper{"cachable": cachable, "null": &nullHelper{}}
+	helper := New(func(name string) api.Helper { return helpers[name] })
+	key := func(config string) string {
+		ctx := context.WithValue(context.Background(), api.HelperConfigKey, []byte(config))
+		return helper.CacheKeyWithContext(ctx, api.GetCredentialsRequest{URI: "https://example.com/foo"})
+	}
+
+	assert.NotEmpty(t, key(`{"helpers": [{"helper": "cachable"}, {"helper": "null"}]}`))
+	assert.Empty(t, key(`{"mode": "merge_headers", "helpers": [{"helper": "cachable"}, {"helper": "null"}]}`))
+	assert.Empty(t, key(`{"helpers": [{"helper": "unknown"}]}`))
+}
+
+// nullHelper cannot be cached.
+type nullHelper struct{ fakeHelper }
+
+func (*nullHelper) CacheKey(api.GetCredentialsRequest) string {
+	return ""
+}
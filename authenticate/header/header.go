@@ -0,0 +1,288 @@
+package header
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tweag/credential-helper/api"
+	"github.com/tweag/credential-helper/authenticate/internal/helperconfig"
+	"github.com/tweag/credential-helper/authenticate/internal/lookupchain"
+)
+
+// Values of configFragment.CacheScope
+const (
+	CacheScopeHost       = "host"
+	CacheScopePathPrefix = "path_prefix"
+	CacheScopeURI        = "uri"
+	CacheScopeNone       = "none"
+)
+
+// Header is a credential helper that is driven purely by its config.
+// It renders headers from templates over the bindings of the lookup chain.
+type Header struct{}
+
+// CacheKey returns a cache key for the given request.
+// Without the helper config, no cache key is returned (do not cache).
+func (h *Header) CacheKey(req api.GetCredentialsRequest) string {
+	return ""
+}
+
+// CacheKeyWithContext returns a cache key for the given request, scoped by the cache_scope of the config.
+func (h *Header) CacheKeyWithContext(ctx context.Context, req api.GetCredentialsRequest) string {
+	cfg, err := configFromContext(ctx)
+	if err != nil {
+		return ""
+	}
+	scope, err := cacheScope(cfg, req.URI)
+	if err != nil || len(scope) == 0 {
+		return ""
+	}
+	return "header:" + scope
+}
+
+func (h *Header) Resolver(ctx context.Context) (api.Resolver, error) {
+	cfg, err := configFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting configuration fragment for header helper: %w", err)
+	}
+	return &HeaderResolver{config: cfg}, nil
+}
+
+func (h *Header) SetupInstructionsForURI(ctx context.Context, uri string) string {
+	cfg, err := configFromContext(ctx)
+	if err != nil {
+		return fmt.Sprintf("%s uses the header helper, but due to a configuration parsing issue, no further setup instructions are available: %v", uri, err)
+	}
+	templates, err := cfg.templates()
+	if err != nil {
+		return fmt.Sprintf("%s uses the header helper, but the configuration is invalid: %v", uri, err)
+	}
+	bindings, err := cfg.references(templates)
+	if err != nil {
+		return fmt.Sprintf("%s uses the header helper, but the configuration is invalid: %v", uri, err)
+	}
+
+	chain := lookupchain.New(cfg.LookupChain)
+	var instructions []string
+	for _, binding := range bindings {
+		instructions = append(instructions, chain.SetupInstructions(binding, fmt.Sprintf("value of the binding %q", binding)))
+	}
+	return fmt.Sprintf(`%s uses the header helper.
+
+The helper sends the headers %s, composed from the following secrets.
+
+%s`, uri, strings.Join(sortedNames(templates), ", "), strings.Join(instructions, "\n\n"))
+}
+
+type HeaderResolver struct {
+	config configFragment
+}
+
+// Get implements the get command of the credential-helper spec:
+//
+// https://github.com/EngFlow/credential-helper-spec/blob/main/spec.md#get
+func (h *HeaderResolver) Get(ctx context.Context, req api.GetCredentialsRequest) (api.GetCredentialsResponse, error) {
+	templates, err := h.config.templates()
+	if err != nil {
+		return api.GetCredentialsResponse{}, err
+	}
+	bindings, err := h.config.references(templates)
+	if err != nil {
+		return api.GetCredentialsResponse{}, err
+	}
+	var ttl time.Duration
+	if len(h.config.TTL) > 0 {
+		ttl, err = time.ParseDuration(h.config.TTL)
+		if err != nil || ttl <= 0 {
+			return api.GetCredentialsResponse{}, fmt.Errorf("invalid ttl %q: must be a positive duration, like \"1h\"", h.config.TTL)
+		}
+	}
+
+	chain := lookupchain.New(h.config.LookupChain)
+	chain.Prefetch(bindings...)
+	headers := make(map[string][]string, len(templates))
+	var results []lookupchain.Result
+	for _, name := range sortedNames(templates) {
+		var result lookupchain.Result
+		if name == "Authorization" && h.config.Basic != nil {
+			result, err = basicAuthorization(chain, *h.config.Basic)
+		} else {
+			result, err = chain.RenderResult(templates[name])
+		}
+		if err != nil {
+			return api.GetCredentialsResponse{}, fmt.Errorf("rendering header %s: %w", name, err)
+		}
+		headers[name] = []string{result.Value}
+		results = append(results, result)
+	}
+	if ttl > 0 {
+		results = append(results, lookupchain.Result{Expires: time.Now().Add(ttl)})
+	}
+
+	resp := api.GetCredentialsResponse{Headers: headers}
+	// without a ttl or expiring secrets, the response is not cached
+	if expires := lookupchain.EarliestExpiry(results...); !expires.IsZero() {
+		resp.Expires = expires.UTC().Format(time.RFC3339)
+	}
+	return resp, nil
+}
+
+// BasicAuth describes the username and password of HTTP Basic authentication.
+type BasicAuth struct {
+	// Username is a template for the username.
+	Username string `json:"username"`
+	// Password is a template for the password.
+	Password string `json:"password"`
+}
+
+type configFragment struct {
+	// Headers maps header names to templates over bindings of the lookup chain,
+	// like {"X-Api-Key": "{{default}}"}.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Basic sends the Authorization header using HTTP Basic authentication.
+	Basic *BasicAuth `json:"basic,omitempty"`
+	// Bearer is a template for a bearer token sent in the Authorization header.
+	Bearer string `json:"bearer,omitempty"`
+	// TTL is the optional time the headers are cached, like "1h".
+	// Secrets with a known expiry shorten it.
+	TTL string `json:"ttl,omitempty"`
+	// CacheScope decides which requests share cached headers:
+	// "host" (default), "path_prefix", "uri" or "none".
+	CacheScope string `json:"cache_scope,omitempty"`
+	// PathPrefixSegments is the number of path segments used by the "path_prefix" cache scope.
+	// Defaults to 1.
+	PathPrefixSegments int `json:"path_prefix_segments,omitempty"`
+	// LookupChain defines the order in which secrets are looked up from sources.
+	// It defaults to the source "env" with the variable CREDENTIAL_HELPER_HEADER_TOKEN.
+	LookupChain lookupchain.Config `json:"lookup_chain"`
+}
+
+// basicPlaceholder stands for the Authorization header of basic in the templates.
+const basicPlaceholder = "Basic"
+
+// templates returns the templates of all headers, including the Authorization header of basic and bearer.
+func (c configFragment) templates() (map[string]string, error) {
+	templates := make(map[string]string, len(c.Headers)+1)
+	for name, template := range c.Headers {
+		if len(name) == 0 {
+			return nil, errors.New("header names must not be empty")
+		}
+		templates[name] = template
+	}
+	var authorization string
+	switch {
+	case c.Basic != nil && len(c.Bearer) > 0:
+		return nil, errors.New("basic and bearer both set the Authorization header: choose one")
+	case c.Basic != nil:
+		// rendered by basicAuthorization
+		authorization = basicPlaceholder
+	case len(c.Bearer) > 0:
+		authorization = "Bearer " + c.Bearer
+	}
+	if len(authorization) > 0 {
+		for name := range c.Headers {
+			if strings.EqualFold(name, "Authorization") {
+				return nil, fmt.Errorf("header %s conflicts with basic or bearer", name)
+			}
+		}
+		templates["Authorization"] = authorization
+	}
+	if len(templates) == 0 {
+		return nil, errors.New("header helper needs at least one of headers, basic or bearer")
+	}
+	return templates, nil
+}
+
+func configFromContext(ctx context.Context) (configFragment, error) {
+	return helperconfig.FromContext(ctx, configFragment{
+		CacheScope:         CacheScopeHost,
+		PathPrefixSegments: 1,
+		LookupChain: lookupchain.Default([]lookupchain.Source{
+			&lookupchain.Env{
+				Source:  "env",
+				Name:    "CREDENTIAL_HELPER_HEADER_TOKEN",
+				Binding: "default",
+			},
+		}),
+	})
+}
+
+// cacheScope returns the part of the uri that identifies cached headers.
+// An empty scope disables caching.
+func cacheScope(cfg configFragment, uri string) (string, error) {
+	parsedURL, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	switch cfg.CacheScope {
+	case CacheScopeHost:
+		return parsedURL.Scheme + "://" + parsedURL.Host, nil
+	case CacheScopePathPrefix:
+		segments := strings.Split(strings.TrimPrefix(parsedURL.Path, "/"), "/")
+		if len(segments) > cfg.PathPrefixSegments {
+			segments = segments[:cfg.PathPrefixSegments]
+		}
+		return parsedURL.Scheme + "://" + parsedURL.Host + "/" + strings.Join(segments, "/"), nil
+	case CacheScopeURI:
+		return uri, nil
+	case CacheScopeNone:
+		return "", nil
+	}
+	return "", fmt.Errorf(`unknown cache_scope %q. Possible values are "host", "path_prefix", "uri" and "none"`, cfg.CacheScope)
+}
+
+// basicAuthorization renders the username and password and encodes them for HTTP Basic authentication.
+func basicAuthorization(chain *lookupchain.LookupChain, basic BasicAuth) (lookupchain.Result, error) {
+	username, err := chain.RenderResult(basic.Username)
+	if err != nil {
+		return lookupchain.Result{}, fmt.Errorf("username: %w", err)
+	}
+	password, err := chain.RenderResult(basic.Password)
+	if err != nil {
+		return lookupchain.Result{}, fmt.Errorf("password: %w", err)
+	}
+	return lookupchain.Result{
+		Value:   "Basic " + base64.StdEncoding.EncodeToString([]byte(username.Value+":"+password.Value)),
+		Expires: lookupchain.EarliestExpiry(username, password),
+	}, nil
+}
+
+// references returns all bindings referenced by the templates and the credentials of basic.
+func (c configFragment) references(templates map[string]string) ([]string, error) {
+	sources := make([]string, 0, len(templates)+2)
+	for _, name := range sortedNames(templates) {
+		if name == "Authorization" && c.Basic != nil {
+			sources = append(sources, c.Basic.Username, c.Basic.Password)
+		} else {
+			sources = append(sources, templates[name])
+		}
+	}
+	var bindings []string
+	for _, template := range sources {
+		referenced, err := lookupchain.References(template)
+		if err != nil {
+			return nil, err
+		}
+		for _, binding := range referenced {
+			if !slices.Contains(bindings, binding) {
+				bindings = append(bindings, binding)
+			}
+		}
+	}
+	return bindings, nil
+}
+
+func sortedNames(templates map[string]string) []string {
+	names := make([]string, 0, len(templates))
+	for name := range templates {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,84 @@
+package header
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tweag/credential-helper/api"
+)
+
+func withConfig(config string) context.Context {
+	return context.WithValue(context.Background(), api.HelperConfigKey, []byte(config))
+}
+
+func get(t *testing.T, ctx context.Context) (api.GetCredentialsResponse, error) {
+	t.Helper()
+	resolver, err := (&Header{}).Resolver(ctx)
+	assert.NoError(t, err)
+	return resolver.Get(ctx, api.GetCredentialsRequest{URI: "https://api.acme.corp/v1/files/a.tar.gz"})
+}
+
+func TestHeaders(t *testing.T) {
+	t.Setenv("CREDENTIAL_HELPER_HEADER_TOKEN", "t0k3n")
+
+	resp, err := get(t, withConfig(`{"bearer": "{{default}}", "headers": {"X-Team": "builds", "X-Api-Key": "{{ default | prefix:'key-' }}"}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		"Authorization": {"Bearer t0k3n"},
+		"X-Team":        {"builds"},
+		"X-Api-Key":     {"key-t0k3n"},
+	}, resp.Headers)
+	// no ttl and no expiring secrets: not cached
+	assert.Empty(t, resp.Expires)
+
+	resp, err = get(t, withConfig(`{
+  "basic": {"username": "robot", "password": "{{password}}"},
+  "ttl": "1h",
+  "lookup_chain": [{"source": "static", "name": "s3cret", "binding": "password"}]
+}`))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Basic cm9ib3Q6czNjcmV0"}, resp.Headers["Authorization"])
+	expires, err := time.Parse(time.RFC3339, resp.Expires)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), expires, 5*time.Second)
+
+	_, err = get(t, withConfig(`{"basic": {"username": "robot", "password": "x"}, "bearer": "{{default}}"}`))
+	assert.ErrorContains(t, err, "choose one")
+	_, err = get(t, withConfig(`{"bearer": "{{default}}", "headers": {"authorization": "x"}}`))
+	assert.ErrorContains(t, err, "conflicts")
+	_, err = get(t, withConfig(`{"bearer": "{{default}}", "ttl": "soon"}`))
+	assert.ErrorContains(t, err, "invalid ttl")
+	_, err = get(t, withConfig(`{}`))
+	assert.ErrorContains(t, err, "at least one")
+
+	os.Unsetenv("CREDENTIAL_HELPER_HEADER_TOKEN")
+	_, err = get(t, withConfig(`{"bearer": "{{default}}"}`))
+	assert.Error(t, err)
+}
+
+func TestCacheScope(t *testing.T) {
+	helper := &Header{}
+	key := func(config, uri string) string {
+		return helper.CacheKeyWithContext(withConfig(config), api.GetCredentialsRequest{URI: uri})
+	}
+
+	assert.Empty(t, helper.CacheKey(api.GetCredentialsRequest{URI: "https://api.acme.corp/"}))
+
+	host := `{"bearer": "{{default}}"}`
+	assert.NotEmpty(t, key(host, "https://api.acme.corp/a"))
+	assert.Equal(t, key(host, "https://api.acme.corp/a"), key(host, "https://api.acme.corp/b/c"))
+	assert.NotEqual(t, key(host, "https://api.acme.corp/a"), key(host, "https://files.acme.corp/a"))
+
+	prefix := `{"bearer": "{{default}}", "cache_scope": "path_prefix", "path_prefix_segments": 2}`
+	assert.Equal(t, key(prefix, "https://api.acme.corp/org/repo/a"), key(prefix, "https://api.acme.corp/org/repo/b/c"))
+	assert.NotEqual(t, key(prefix, "https://api.acme.corp/org/repo/a"), key(prefix, "https://api.acme.corp/org/other/a"))
+
+	uri := `{"bearer": "{{default}}", "cache_scope": "uri"}`
+	assert.NotEqual(t, key(uri, "https://api.acme.corp/a"), key(uri, "https://api.acme.corp/b"))
+
+	assert.Empty(t, key(`{"bearer": "{{default}}", "cache_scope": "none"}`, "https://api.acme.corp/a"))
+	assert.Empty(t, key(`{"bearer": "{{default}}", "cache_scope": "everything"}`, "https://api.acme.corp/a"))
+}
@@ -0,0 +1,90 @@
+package headerrules
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/tweag/credential-helper/api"
+	"github.com/tweag/credential-helper/authenticate/internal/lookupchain"
+	"github.com/tweag/credential-helper/config"
+)
+
+// Apply transforms the headers of the response according to the rules.
+// Values may reference bindings of the lookup chain found in the helper config of the context.
+func Apply(ctx context.Context, rules []config.HeaderRule, resp api.GetCredentialsResponse) (api.GetCredentialsResponse, error) {
+	if len(rules) == 0 {
+		return resp, nil
+	}
+	chain, err := chainFromContext(ctx)
+	if err != nil {
+		return api.GetCredentialsResponse{}, err
+	}
+
+	headers := make(map[string][]string, len(resp.Headers))
+	for name, values := range resp.Headers {
+		headers[name] = values
+	}
+
+	for i, rule := range rules {
+		if len(rule.Name) == 0 {
+			return api.GetCredentialsResponse{}, fmt.Errorf("header rule %d: name must be set", i)
+		}
+		switch rule.Action {
+		case config.HeaderActionAdd, config.HeaderActionSet:
+			value, err := chain.Render(rule.Value)
+			if err != nil {
+				return api.GetCredentialsResponse{}, fmt.Errorf("header rule %d: rendering value of %s: %w", i, rule.Name, err)
+			}
+			var existing []string
+			if rule.Action == config.HeaderActionAdd {
+				existing = remove(headers, rule.Name)
+			} else {
+				remove(headers, rule.Name)
+			}
+			headers[rule.Name] = append(existing, value)
+		case config.HeaderActionRemove:
+			remove(headers, rule.Name)
+		case config.HeaderActionRename:
+			if len(rule.To) == 0 {
+				return api.GetCredentialsResponse{}, fmt.Errorf("header rule %d: to must be set for action %q", i, rule.Action)
+			}
+			values := remove(headers, rule.Name)
+			if len(values) > 0 {
+				headers[rule.To] = append(remove(headers, rule.To), values...)
+			}
+		default:
+			return api.GetCredentialsResponse{}, fmt.Errorf(`header rule %d: unknown action %q. Possible values are "add", "set", "remove" and "rename"`, i, rule.Action)
+		}
+	}
+
+	resp.Headers = headers
+	return resp, nil
+}
+
+// remove deletes all headers matching the name (case-insensitive) and returns their values.
+func remove(headers map[string][]string, name string) []string {
+	var values []string
+	for key, keyValues := range headers {
+		if strings.EqualFold(key, name) {
+			values = append(values, keyValues...)
+			delete(headers, key)
+		}
+	}
+	return values
+}
+
+func chainFromContext(ctx context.Context) (*lookupchain.LookupChain, error) {
+	var cfg struct {
+		LookupChain lookupchain.Config `json:"lookup_chain"`
+	}
+	rawConfig, ok := ctx.Value(api.HelperConfigKey).([]byte)
+	if ok {
+		// other fields of the helper config are defined by the helper and ignored here
+		if err := json.Unmarshal(rawConfig, &cfg); err != nil {
+			return nil, fmt.Errorf("reading lookup chain from helper config: %w", err)
+		}
+	}
+	return lookupchain.New(cfg.LookupChain), nil
+}
@@ -0,0 +1,100 @@
+package headerrules
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tweag/credential-helper/api"
+	"github.com/tweag/credential-helper/config"
+)
+
+func TestApply(t *testing.T) {
+	ctx := context.WithValue(context.Background(), api.HelperConfigKey, []byte(`{
+  "region": "ignored by the header rules",
+  "lookup_chain": [{"source": "static", "name": "t0k3n"}]
+}`))
+	resp := api.GetCredentialsResponse{
+		Expires: "2030-01-01T00:00:00Z",
+		Headers: map[string][]string{
+			"Authorization": {"Basic old"},
+			"X-Amz-Date":    {"20300101T000000Z"},
+			"X-Trace":       {"a"},
+		},
+	}
+
+	testCases := []struct {
+		name  string
+		rules []config.HeaderRule
+		want  map[string][]string
+	}{
+		{
+			name:  "no rules",
+			rules: nil,
+			want:  resp.Headers,
+		},
+		{
+			name:  "add",
+			rules: []config.HeaderRule{{Action: config.HeaderActionAdd, Name: "x-trace", Value: "b"}},
+			want: map[string][]string{
+				"Authorization": {"Basic old"},
+				"X-Amz-Date":    {"20300101T000000Z"},
+				"x-trace":       {"a", "b"},
+			},
+		},
+		{
+			name:  "set overrides with template",
+			rules: []config.HeaderRule{{Action: config.HeaderActionSet, Name: "Authorization", Value: "Bearer {{default}}"}},
+			want: map[string][]string{
+				"Authorization": {"Bearer t0k3n"},
+				"X-Amz-Date":    {"20300101T000000Z"},
+				"X-Trace":       {"a"},
+			},
+		},
+		{
+			name: "remove and rename",
+			rules: []config.HeaderRule{
+				{Action: config.HeaderActionRemove, Name: "authorization"},
+				{Action: config.HeaderActionRename, Name: "X-Trace", To: "X-Request-Id"},
+			},
+			want: map[string][]string{
+				"X-Amz-Date":   {"20300101T000000Z"},
+				"X-Request-Id": {"a"},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Apply(ctx, tc.rules, resp)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got.Headers)
+			assert.Equal(t, resp.Expires, got.Expires)
+		})
+	}
+	// the original response is not modified
+	assert.Equal(t, []string{"Basic old"}, resp.Headers["Authorization"])
+}
+
+func TestApplyErrors(t *testing.T) {
+	ctx := context.WithValue(context.Background(), api.HelperConfigKey, []byte(`{"lookup_chain": [{"source": "static", "name": "t0k3n"}]}`))
+	resp := api.GetCredentialsResponse{Headers: map[string][]string{"Authorization": {"Basic old"}}}
+
+	testCases := []struct {
+		name  string
+		rules []config.HeaderRule
+		err   string
+	}{
+		{"missing name", []config.HeaderRule{{Action: config.HeaderActionRemove}}, "name must be set"},
+		{"unknown action", []config.HeaderRule{{Action: "replace", Name: "Authorization"}}, "unknown action"},
+		{"rename without to", []config.HeaderRule{{Action: config.HeaderActionRename, Name: "Authorization"}}, "to must be set"},
+		{"unknown binding", []config.HeaderRule{{Action: config.HeaderActionSet, Name: "Authorization", Value: "Bearer {{missing}}"}}, "rendering value of Authorization"},
+		{"unterminated template", []config.HeaderRule{{Action: config.HeaderActionSet, Name: "Authorization", Value: "Bearer {{default"}}, "unterminated"},
+		{"unknown transform", []config.HeaderRule{{Action: config.HeaderActionAdd, Name: "X-Token", Value: "{{ default | rot13 }}"}}, "unknown transform"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Apply(ctx, tc.rules, resp)
+			assert.ErrorContains(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package lookupchain
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/tweag/credential-helper/agent/locate"
+	"github.com/tweag/credential-helper/api"
+	"github.com/tweag/credential-helper/secretstore"
+)
+
+const SourceEncryptedBundle = "encrypted_bundle"
+
+// EncryptedBundle reads an entry of an encrypted bundle, which is usually committed to the workspace.
+// Bundles are created and edited using the bundle command.
+type EncryptedBundle struct {
+	// Source is the name of the source used to look up the secret.
+	// It must be "encrypted_bundle".
+	Source string `json:"source"`
+	// Path is the path of the bundle. It is subject to prefix expansion.
+	// Relative paths are resolved against the workspace directory.
+	// Defaults to %workspace%/.tweag-credential-helper.bundle.
+	Path string `json:"path,omitempty"`
+	// Key is the name of the entry in the bundle.
+	Key string `json:"key"`
+	// IdentityEnv is the environment variable holding the identity used to decrypt the bundle.
+	// Defaults to CREDENTIAL_HELPER_BUNDLE_IDENTITY.
+	IdentityEnv string `json:"identity_env,omitempty"`
+	// IdentityFile is the file holding the identity. It is subject to prefix expansion.
+	// Defaults to tweag-credential-helper/bundle-identity in the user config directory.
+	IdentityFile string `json:"identity_file,omitempty"`
+	// IdentityService is the name of the identity in the keyring.
+	// Defaults to tweag-credential-helper:bundle-identity.
+	IdentityService string `json:"identity_service,omitempty"`
+	// Binding binds the value of the entry to a well-known name in the helper.
+	// If not specified, the value is bound to the default secret of the helper.
+	Binding string `json:"binding,omitempty"`
+}
+
+func (e *EncryptedBundle) Lookup(binding string) (string, error) {
+	if e.Binding != binding {
+		return "", &NotFoundErr{}
+	}
+	path := e.resolvedPath()
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return "", &NotFoundErr{reason: err.Error()}
+	}
+	identity, err := e.identitySources().Find()
+	if errors.Is(err, secretstore.ErrNotFound) {
+		return "", &NotFoundErr{reason: fmt.Sprintf("no identity to decrypt bundle %s found in $%s, %s or the keyring", path, e.IdentityEnv, e.IdentityFile)}
+	}
+	if err != nil {
+		return "", err
+	}
+	bundle, err := secretstore.ReadBundle(path, identity)
+	if err != nil {
+		return "", err
+	}
+	value, err := bundle.Get(e.Key)
+	if errors.Is(err, secretstore.ErrNotFound) {
+		return "", &NotFoundErr{reason: fmt.Sprintf("bundle %s has no entry %s", path, e.Key)}
+	}
+	return value, err
+}
+
+func (e *EncryptedBundle) Canonicalize() {
+	e.Source = "encrypted_bundle"
+	if e.Binding == "" {
+		e.Binding = "default"
+	}
+	if e.Path == "" {
+		e.Path = secretstore.DefaultBundlePath
+	}
+	defaults := secretstore.DefaultIdentitySources()
+	if e.IdentityEnv == "" {
+		e.IdentityEnv = defaults.Env
+	}
+	if e.IdentityFile == "" {
+		e.IdentityFile = defaults.File
+	}
+	if e.IdentityService == "" {
+		e.IdentityService = defaults.Service
+	}
+}
+
+func (e *EncryptedBundle) SetupInstructions(binding string) (string, bool) {
+	if e.Binding != binding {
+		return "", false
+	}
+	path := e.resolvedPath()
+	var status string
+	identity, identityErr := e.identitySources().Find()
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		status = "BUNDLE NOT FOUND"
+	} else if identityErr != nil {
+		status = "NO IDENTITY"
+	} else if bundle, err := secretstore.ReadBundle(path, identity); err != nil {
+		status = fmt.Sprintf("ERROR: %v", err)
+	} else if _, err := bundle.Get(e.Key); err != nil {
+		status = "ENTRY NOT FOUND"
+	} else {
+		status = "FOUND"
+	}
+	return fmt.Sprintf(` - Get access to the entry %s of the encrypted bundle %s (status: %s):
+   Create an identity and ask someone with access to add its recipient to the bundle:
+    $ %s bundle keygen
+    $ %s bundle add-recipient [recipient]`, e.Key, path, status, os.Args[0], os.Args[0]), true
+}
+
+func (e *EncryptedBundle) resolvedPath() string {
+	path := locate.ExpandPath(e.Path)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(os.Getenv(api.WorkspaceEnv), path)
+	}
+	return path
+}
+
+func (e *EncryptedBundle) identitySources() secretstore.IdentitySources {
+	return secretstore.IdentitySources{
+		Env:     e.IdentityEnv,
+		File:    e.IdentityFile,
+		Service: e.IdentityService,
+	}
+}
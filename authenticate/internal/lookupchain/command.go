@@ -0,0 +1,179 @@
+package lookupchain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/tweag/credential-helper/agent/locate"
+	"github.com/tweag/credential-helper/api"
+	"github.com/tweag/credential-helper/logging"
+)
+
+const SourceCommand = "command"
+
+// Values of Command.OnFailure
+const (
+	// CommandOnFailureError turns a failing command into an error, which stops the lookup.
+	CommandOnFailureError = "error"
+	// CommandOnFailureNotFound treats a failing command like a missing secret, so the lookup continues with the next source.
+	CommandOnFailureNotFound = "not_found"
+)
+
+const defaultCommandTimeout = 30 * time.Second
+
+type Command struct {
+	// Source is the name of the source used to look up the secret.
+	// It must be "command".
+	Source string `json:"source"`
+	// Command is the argv of the command that prints the secret to stdout.
+	// It is executed directly (without a shell) in the workspace directory.
+	// The first element is subject to prefix expansion.
+	Command []string `json:"command"`
+	// Timeout is the maximum runtime of the command in Go duration format. Defaults to 30s.
+	Timeout string `json:"timeout,omitempty"`
+	// OnFailure decides what happens if the command exits with a non-zero status:
+	// "error" (default) or "not_found".
+	OnFailure string `json:"on_failure,omitempty"`
+	// JSONField is an optional dot-separated path of a string field in the JSON output of the command.
+	JSONField string `json:"json_field,omitempty"`
+	// Binding binds the output of the command to a well-known name in the helper.
+	// If not specified, the value is bound to the default secret of the helper.
+	Binding string `json:"binding,omitempty"`
+}
+
+type commandResult struct {
+	done   chan struct{}
+	stdout []byte
+	err    error
+}
+
+// commandResults memoizes the result of each command for the lifetime of the process.
+// Helpers often look up several bindings, which may all come from the output of a single command.
+// The lock only guards the map: different commands run concurrently.
+var commandResults = struct {
+	sync.Mutex
+	results map[string]*commandResult
+}{results: make(map[string]*commandResult)}
+
+func (c *Command) Lookup(binding string) (string, error) {
+	if c.Binding != binding {
+		return "", &NotFoundErr{}
+	}
+	if len(c.Command) == 0 {
+		return "", errors.New("command must not be empty")
+	}
+	switch c.OnFailure {
+	case CommandOnFailureError, CommandOnFailureNotFound:
+	default:
+		return "", fmt.Errorf(`unknown on_failure %q. Possible values are "error" and "not_found"`, c.OnFailure)
+	}
+	timeout, err := time.ParseDuration(c.Timeout)
+	if err != nil {
+		return "", fmt.Errorf("parsing timeout: %w", err)
+	}
+
+	stdout, err := runCommand(c.Command, "", nil, timeout)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && c.OnFailure == CommandOnFailureNotFound {
+		return "", &NotFoundErr{reason: err.Error()}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	value := string(stdout)
+	if len(c.JSONField) > 0 {
+		value, err = jsonField(stdout, c.JSONField)
+		if err != nil {
+			return "", fmt.Errorf("reading field %q of the output of %s: %w", c.JSONField, c.Command[0], err)
+		}
+	}
+	return strings.TrimRightFunc(value, unicode.IsSpace), nil
+}
+
+// runCommand executes argv and returns its stdout.
+// The result is memoized for the lifetime of the process, keyed by argv, stdin and extra environment variables.
+// Concurrent calls with the same key share a single execution.
+func runCommand(argv []string, stdin string, env []string, timeout time.Duration) ([]byte, error) {
+	// arguments are quoted one by one, so that ["a b"] and ["a", "b"] are different keys
+	var key strings.Builder
+	for _, arg := range append(append(slices.Clone(argv), env...), stdin) {
+		fmt.Fprintf(&key, "%q ", arg)
+	}
+
+	commandResults.Lock()
+	result, ok := commandResults.results[key.String()]
+	if !ok {
+		result = &commandResult{done: make(chan struct{})}
+		commandResults.results[key.String()] = result
+	}
+	commandResults.Unlock()
+	if ok {
+		<-result.done
+		logging.Debugf("reusing output of command %s", argv[0])
+		return result.stdout, result.err
+	}
+
+	result.stdout, result.err = execCommand(argv, stdin, env, timeout)
+	close(result.done)
+	return result.stdout, result.err
+}
+
+// execCommand executes argv without memoization.
+func execCommand(argv []string, stdin string, env []string, timeout time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, locate.ExpandPath(argv[0]), argv[1:]...)
+	cmd.Dir = os.Getenv(api.WorkspaceEnv)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	start := time.Now()
+	err := cmd.Run()
+	logging.Debugf("command %s finished in %v", argv[0], time.Since(start))
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("command %s timed out after %v", argv[0], timeout)
+	} else if err != nil {
+		err = fmt.Errorf("running command %s: %w: %s", argv[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), err
+}
+
+func (c *Command) Canonicalize() {
+	c.Source = "command"
+	if c.Binding == "" {
+		c.Binding = "default"
+	}
+	if c.Timeout == "" {
+		c.Timeout = defaultCommandTimeout.String()
+	}
+	if c.OnFailure == "" {
+		c.OnFailure = CommandOnFailureError
+	}
+}
+
+func (c *Command) SetupInstructions(binding string) (string, bool) {
+	if c.Binding != binding {
+		return "", false
+	}
+	if len(c.Command) == 0 {
+		return " - Configure a command that prints the secret (status: NO COMMAND CONFIGURED)", true
+	}
+	status := "FOUND"
+	if _, err := exec.LookPath(locate.ExpandPath(c.Command[0])); err != nil {
+		status = "NOT FOUND"
+	}
+	return fmt.Sprintf(" - Ensure that the following command prints the secret (executable status: %s):\n    $ %s", status, strings.Join(c.Command, " ")), true
+}
@@ -0,0 +1,81 @@
+package lookupchain
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/tweag/credential-helper/agent/locate"
+	"github.com/tweag/credential-helper/api"
+	"github.com/tweag/credential-helper/secretstore"
+)
+
+const SourceEncryptedFile = "encrypted_file"
+
+// EncryptedFile looks up a secret in the encrypted file secret store.
+// This is useful on machines without a system keyring, like headless Linux machines.
+type EncryptedFile struct {
+	// Source is the name of the source used to look up the secret.
+	// It must be "encrypted_file".
+	Source string `json:"source"`
+	// Service is the name of the secret in the store.
+	Service string `json:"service"`
+	// Path is the path of the encrypted file. It is subject to prefix expansion.
+	// If not specified, $CREDENTIAL_HELPER_SECRET_STORE_FILE or the default location in the user config directory is used.
+	Path string `json:"path,omitempty"`
+	// Binding binds the value of the secret to a well-known name in the helper.
+	// If not specified, the value is bound to the default secret of the helper.
+	Binding string `json:"binding,omitempty"`
+}
+
+func (e *EncryptedFile) Lookup(binding string) (string, error) {
+	if e.Binding != binding {
+		return "", &NotFoundErr{}
+	}
+	val, err := e.store().Get(e.Service)
+	if errors.Is(err, secretstore.ErrNotFound) {
+		return "", &NotFoundErr{reason: err.Error()}
+	}
+	if err != nil {
+		return "", err
+	}
+	return val, nil
+}
+
+func (e *EncryptedFile) Canonicalize() {
+	e.Source = "encrypted_file"
+	if e.Binding == "" {
+		e.Binding = "default"
+	}
+}
+
+func (e *EncryptedFile) SetupInstructions(binding string) (string, bool) {
+	if e.Binding != binding {
+		return "", false
+	}
+	store := e.store()
+	_, getErr := store.Get(e.Service)
+	var status string
+	if errors.Is(getErr, secretstore.ErrNotFound) {
+		status = "NOT SET"
+	} else if getErr != nil {
+		status = "ERROR DECRYPTING"
+	} else {
+		status = "SET"
+	}
+	instructions := fmt.Sprintf(` - Add the secret to the %s under the %s service name (status: %s):
+    $ %s setup-keyring --store file -f secret.txt %s`, store.Name(), e.Service, status, os.Args[0], e.Service)
+	if len(e.Path) > 0 {
+		instructions = fmt.Sprintf(` - Add the secret to the %s under the %s service name (status: %s):
+    $ %s=%s %s setup-keyring --store file -f secret.txt %s`, store.Name(), e.Service, status, api.SecretStoreFileEnv, store.Path, os.Args[0], e.Service)
+	}
+	return instructions, true
+}
+
+func (e *EncryptedFile) store() *secretstore.File {
+	store := secretstore.DefaultFile()
+	if len(e.Path) > 0 {
+		store.Path = locate.ExpandPath(e.Path)
+	}
+	return store
+}
@@ -0,0 +1,142 @@
+package lookupchain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"unicode"
+
+	"github.com/tweag/credential-helper/agent/locate"
+	"github.com/tweag/credential-helper/api"
+)
+
+const SourceFile = "file"
+
+type File struct {
+	// Source is the name of the source used to look up the secret.
+	// It must be "file".
+	Source string `json:"source"`
+	// Path is the path of the file containing the secret.
+	// It is subject to prefix expansion. Relative paths are resolved against the workspace directory.
+	Path string `json:"path"`
+	// StrictPermissions refuses files that are readable by the group or by others.
+	// It has no effect on Windows.
+	StrictPermissions bool `json:"strict_permissions,omitempty"`
+	// JSONField is an optional dot-separated path of a string field in a JSON file (like "data.token").
+	// If set, the file is parsed as JSON and only the value of the field is used.
+	JSONField string `json:"json_field,omitempty"`
+	// Binding binds the value of the file to a well-known name in the helper.
+	// If not specified, the value is bound to the default secret of the helper.
+	Binding string `json:"binding,omitempty"`
+}
+
+func (f *File) Lookup(binding string) (string, error) {
+	if f.Binding != binding {
+		return "", &NotFoundErr{}
+	}
+	path := f.resolvedPath()
+	if err := f.checkPermissions(path); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", &NotFoundErr{reason: err.Error()}
+		}
+		return "", err
+	}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", &NotFoundErr{reason: err.Error()}
+	}
+	if err != nil {
+		return "", err
+	}
+	value := string(content)
+	if len(f.JSONField) > 0 {
+		value, err = jsonField(content, f.JSONField)
+		if err != nil {
+			return "", fmt.Errorf("reading field %q of %s: %w", f.JSONField, path, err)
+		}
+	}
+	return strings.TrimRightFunc(value, unicode.IsSpace), nil
+}
+
+func (f *File) Canonicalize() {
+	f.Source = "file"
+	if f.Binding == "" {
+		f.Binding = "default"
+	}
+}
+
+func (f *File) SetupInstructions(binding string) (string, bool) {
+	if f.Binding != binding {
+		return "", false
+	}
+	path := f.resolvedPath()
+	var status string
+	if err := f.checkPermissions(path); errors.Is(err, fs.ErrNotExist) {
+		status = "NOT FOUND"
+	} else if err != nil {
+		status = fmt.Sprintf("ERROR: %v", err)
+	} else {
+		status = "FOUND"
+	}
+	instruction := fmt.Sprintf(" - Store the secret in the file %s (status: %s)", path, status)
+	if len(f.JSONField) > 0 {
+		instruction += fmt.Sprintf("\n   The file must be a JSON document with the secret in the field %q.", f.JSONField)
+	}
+	if f.StrictPermissions && runtime.GOOS != "windows" {
+		instruction += fmt.Sprintf("\n   The file must not be readable by the group or by others:\n    $ chmod 600 %s", path)
+	}
+	return instruction, true
+}
+
+func (f *File) resolvedPath() string {
+	path := locate.ExpandPath(f.Path)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(os.Getenv(api.WorkspaceEnv), path)
+	}
+	return path
+}
+
+// checkPermissions returns an error if the file doesn't exist
+// or if it is readable by the group or by others and strict permissions are requested.
+func (f *File) checkPermissions(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !f.StrictPermissions || runtime.GOOS == "windows" {
+		return nil
+	}
+	if perm := info.Mode().Perm(); perm&0o044 != 0 {
+		return fmt.Errorf("refusing to read %s: file permissions %04o are too open (readable by group or others)", path, perm)
+	}
+	return nil
+}
+
+// jsonField extracts a string field from a JSON document.
+// The field is addressed by a dot-separated path of object keys.
+func jsonField(content []byte, fieldPath string) (string, error) {
+	var current any
+	if err := json.Unmarshal(content, &current); err != nil {
+		return "", err
+	}
+	for _, key := range strings.Split(fieldPath, ".") {
+		object, ok := current.(map[string]any)
+		if !ok {
+			return "", fmt.Errorf("%q is not an object", key)
+		}
+		current, ok = object[key]
+		if !ok {
+			return "", &NotFoundErr{reason: fmt.Sprintf("field %q not found", key)}
+		}
+	}
+	value, ok := current.(string)
+	if !ok {
+		return "", errors.New("field is not a string")
+	}
+	return value, nil
+}
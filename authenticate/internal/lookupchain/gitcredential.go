@@ -0,0 +1,201 @@
+package lookupchain
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tweag/credential-helper/api"
+	"github.com/tweag/credential-helper/logging"
+)
+
+const SourceGitCredential = "git-credential"
+
+// Fields of a git credential
+const (
+	GitCredentialFieldUsername = "username"
+	GitCredentialFieldPassword = "password"
+)
+
+const gitCredentialTimeout = 30 * time.Second
+
+// GitCredential looks up secrets using the git credential helpers configured for the user
+// (like osxkeychain, libsecret or Git Credential Manager).
+// It speaks the protocol of "git credential fill":
+//
+// https://git-scm.com/docs/git-credential
+type GitCredential struct {
+	// Source is the name of the source used to look up the secret.
+	// It must be "git-credential".
+	Source string `json:"source"`
+	// Field is the field of the credential to use: "password" (default) or "username".
+	Field string `json:"field,omitempty"`
+	// URL is the url to ask git for credentials for.
+	// Defaults to the requested uri.
+	URL string `json:"url,omitempty"`
+	// IncludePath sends the path of the url to git.
+	// This is useful if git is configured to use credential.useHttpPath.
+	IncludePath bool `json:"include_path,omitempty"`
+	// Approve tells git that the credential is valid after a successful lookup ("git credential approve").
+	// This allows git credential helpers like "store" or "cache" to persist credentials obtained interactively.
+	// The credential is approved before it is used, so a stale credential would be stored again. Defaults to false.
+	Approve bool `json:"approve,omitempty"`
+	// Binding binds the value of the field to a well-known name in the helper.
+	// If not specified, the value is bound to the default secret of the helper.
+	Binding string `json:"binding,omitempty"`
+}
+
+func (g *GitCredential) Lookup(binding string) (string, error) {
+	return g.lookup(binding, g.Approve)
+}
+
+// lookup runs "git credential fill" and optionally approves the credential.
+func (g *GitCredential) lookup(binding string, approve bool) (string, error) {
+	if g.Binding != binding {
+		return "", &NotFoundErr{}
+	}
+	switch g.Field {
+	case GitCredentialFieldUsername, GitCredentialFieldPassword:
+	default:
+		return "", fmt.Errorf(`unknown git-credential field %q. Possible values are "username" and "password"`, g.Field)
+	}
+	request, err := g.request()
+	if err != nil {
+		return "", err
+	}
+	credential, err := gitCredential("fill", request)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// git exits with an error if no helper knows the credential and it cannot prompt
+		return "", &NotFoundErr{reason: err.Error()}
+	}
+	if err != nil {
+		return "", err
+	}
+	value := credential[g.Field]
+	if len(value) == 0 {
+		return "", &NotFoundErr{reason: fmt.Sprintf("git credential has no %s", g.Field)}
+	}
+	if approve {
+		if _, err := gitCredential("approve", encodeGitCredential(credential)); err != nil {
+			logging.Errorf("approving git credential: %v", err)
+		}
+	}
+	return value, nil
+}
+
+// request returns the input of "git credential fill" in the format of the git credential protocol.
+func (g *GitCredential) request() (string, error) {
+	parsedURL, err := url.Parse(g.url())
+	if err != nil {
+		return "", fmt.Errorf("parsing url for git credential: %w", err)
+	}
+	if len(parsedURL.Host) == 0 {
+		return "", errors.New("git-credential source needs a url, but no uri was requested")
+	}
+	credential := map[string]string{
+		"protocol": parsedURL.Scheme,
+		"host":     parsedURL.Host,
+	}
+	if g.IncludePath {
+		credential["path"] = strings.TrimPrefix(parsedURL.Path, "/")
+	}
+	return encodeGitCredential(credential), nil
+}
+
+// url returns the url to ask git for credentials for.
+func (g *GitCredential) url() string {
+	if len(g.URL) > 0 {
+		return g.URL
+	}
+	return os.Getenv(api.RequestURIEnv)
+}
+
+// Logout tells git that the credential for the url is invalid ("git credential reject"),
+// so that git credential helpers forget it.
+func (g *GitCredential) Logout(out io.Writer) error {
+	request, err := g.request()
+	if err != nil {
+		return err
+	}
+	if _, err := gitCredential("reject", request); err != nil {
+		return fmt.Errorf("rejecting git credential: %w", err)
+	}
+	fmt.Fprintf(out, "Rejected the git credential for %s\n", g.url())
+	return nil
+}
+
+func gitCredential(action, input string) (map[string]string, error) {
+	// never prompt: there is no terminal when Bazel invokes the helper
+	env := []string{"GIT_TERMINAL_PROMPT=0", "GCM_INTERACTIVE=never"}
+	stdout, err := runCommand([]string{"git", "credential", action}, input, env, gitCredentialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	credential := make(map[string]string)
+	for _, line := range strings.Split(string(stdout), "\n") {
+		key, value, ok := strings.Cut(strings.TrimRight(line, "\r"), "=")
+		if ok {
+			credential[key] = value
+		}
+	}
+	return credential, nil
+}
+
+func encodeGitCredential(credential map[string]string) string {
+	var out strings.Builder
+	// protocol and host come first, since some helpers expect them in this order
+	for _, key := range []string{"protocol", "host", "path", "username", "password"} {
+		if value, ok := credential[key]; ok {
+			fmt.Fprintf(&out, "%s=%s\n", key, value)
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(credential)) {
+		switch key {
+		case "protocol", "host", "path", "username", "password":
+			continue
+		}
+		fmt.Fprintf(&out, "%s=%s\n", key, credential[key])
+	}
+	out.WriteString("\n")
+	return out.String()
+}
+
+func (g *GitCredential) Canonicalize() {
+	g.Source = "git-credential"
+	if g.Binding == "" {
+		g.Binding = "default"
+	}
+	if g.Field == "" {
+		g.Field = GitCredentialFieldPassword
+	}
+}
+
+func (g *GitCredential) SetupInstructions(binding string) (string, bool) {
+	if g.Binding != binding {
+		return "", false
+	}
+	target := g.url()
+	status := "SET"
+	// checking the status must not approve the credential
+	if _, err := g.lookup(binding, false); IsNotFoundErr(err) {
+		status = "NOT SET"
+	} else if err != nil {
+		status = fmt.Sprintf("ERROR: %v", err)
+	}
+	return fmt.Sprintf(` - Store the credential for %s using git (status: %s). For example, clone a repository from the same host with git, or run:
+    $ git credential approve <<EOF
+    url=%s
+    username=<username>
+    password=<password>
+    EOF
+   To make git forget an invalid credential, run:
+    $ %s logout %s`, target, status, target, os.Args[0], target), true
+}
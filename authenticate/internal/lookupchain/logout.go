@@ -0,0 +1,39 @@
+package lookupchain
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// logouter is implemented by sources that keep credentials which can be forgotten.
+type logouter interface {
+	Logout(out io.Writer) error
+}
+
+// Logout makes the sources of the chain forget their credentials:
+// oauth2 sources delete their refresh token and git-credential sources reject the credential of the url.
+func (c *LookupChain) Logout(out io.Writer) error {
+	var found bool
+	for i, entry := range c.config {
+		if skip(entry) {
+			continue
+		}
+		source, err := c.source(i)
+		if err != nil {
+			return err
+		}
+		logouter, ok := source.(logouter)
+		if !ok {
+			continue
+		}
+		found = true
+		if err := logouter.Logout(out); err != nil {
+			return fmt.Errorf("lookup_chain[%d]: %w", i, err)
+		}
+	}
+	if !found {
+		return errors.New("the lookup chain has no oauth2 or git-credential source to log out of")
+	}
+	return nil
+}
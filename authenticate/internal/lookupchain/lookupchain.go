@@ -10,10 +10,13 @@ import (
 	"net/http"
 	"os"
 	"reflect"
+	"slices"
 	"strings"
 	"time"
 
-	keyring "github.com/zalando/go-keyring"
+	"github.com/tweag/credential-helper/api"
+	"github.com/tweag/credential-helper/logging"
+	"github.com/tweag/credential-helper/secretstore"
 	"golang.org/x/oauth2"
 	gauth "golang.org/x/oauth2/google"
 	idtoken "google.golang.org/api/idtoken"
@@ -29,6 +32,9 @@ const (
 
 type LookupChain struct {
 	config Config
+	// templateDepth counts the templates currently being rendered, to detect cycles.
+	templateDepth int
+	session       session
 }
 
 func New(config Config) *LookupChain {
@@ -38,17 +44,33 @@ func New(config Config) *LookupChain {
 // Lookup looks up a binding in the chain.
 // It returns the first value found, or an error.
 func (c *LookupChain) Lookup(binding string) (string, error) {
+	result, err := c.LookupResult(binding)
+	return result.Value, err
+}
+
+// LookupResult looks up a binding in the chain.
+// It returns the first value found together with its metadata, or an error.
+func (c *LookupChain) LookupResult(binding string) (Result, error) {
 	if len(c.config) == 0 {
-		return "", fmt.Errorf("no sources configured to look up binding %q", binding)
+		return Result{}, fmt.Errorf("no sources configured to look up binding %q", binding)
 	}
 	var errs []error
-	for _, entry := range c.config {
-		source, err := c.sourceFor(entry)
+	for i, entry := range c.config {
+		if skip(entry) {
+			continue
+		}
+		source, err := c.source(i)
 		if err != nil {
-			return "", fmt.Errorf("looking up binding %q: %w", binding, err)
+			return Result{}, fmt.Errorf("looking up binding %q: %w", binding, err)
 		}
-		result, err := source.Lookup(binding)
+		result, err := c.resolve(i, source, binding)
 		if err == nil {
+			if len(result.Provenance) == 0 {
+				result.Provenance = fmt.Sprintf("%s (lookup_chain[%d])", entry.Source, i)
+			}
+			if !result.Expires.IsZero() {
+				logging.Debugf("binding %q from %s expires at %s", binding, result.Provenance, result.Expires.Format(time.RFC3339))
+			}
 			return result, nil
 		}
 		if IsNotFoundErr(err) {
@@ -62,15 +84,18 @@ func (c *LookupChain) Lookup(binding string) (string, error) {
 	}
 
 	if len(errs) > 0 {
-		return "", fmt.Errorf("no value found for binding %q after querying: %w", binding, errors.Join(errs...))
+		return Result{}, fmt.Errorf("no value found for binding %q after querying: %w", binding, errors.Join(errs...))
 	}
 
-	return "", &NotFoundErr{reason: fmt.Sprintf("no value found for binding %q after querying %v", binding, strings.Join(sourceNames, ", "))}
+	return Result{}, &NotFoundErr{reason: fmt.Sprintf("no value found for binding %q after querying %v", binding, strings.Join(sourceNames, ", "))}
 }
 
 func (c *LookupChain) SetupInstructions(binding, meaning string) string {
 	instructions := []string{fmt.Sprintf("Instructions for setting up the secret with binding name %q (%s):", binding, meaning)}
 	for i, entry := range c.config {
+		if skip(entry) {
+			continue
+		}
 		source, err := c.sourceFor(entry)
 		if err != nil {
 			instructions = append(instructions, fmt.Sprintf("failed to lookup instuctions for entry %d: %v", i, err))
@@ -117,6 +142,73 @@ func (c *LookupChain) sourceFor(entry ConfigEntry) (Source, error) {
 			return nil, fmt.Errorf("unmarshalling google source: %w", err)
 		}
 		source = &google
+	case SourceFile:
+		var file File
+		if err := decoder.Decode(&file); err != nil {
+			return nil, fmt.Errorf("unmarshalling file source: %w", err)
+		}
+		source = &file
+	case SourceCommand:
+		var command Command
+		if err := decoder.Decode(&command); err != nil {
+			return nil, fmt.Errorf("unmarshalling command source: %w", err)
+		}
+		source = &command
+	case SourceNetrc:
+		var netrc Netrc
+		if err := decoder.Decode(&netrc); err != nil {
+			return nil, fmt.Errorf("unmarshalling netrc source: %w", err)
+		}
+		source = &netrc
+	case SourceGitCredential:
+		var gitCredential GitCredential
+		if err := decoder.Decode(&gitCredential); err != nil {
+			return nil, fmt.Errorf("unmarshalling git-credential source: %w", err)
+		}
+		source = &gitCredential
+	case SourceVault:
+		var vault Vault
+		if err := decoder.Decode(&vault); err != nil {
+			return nil, fmt.Errorf("unmarshalling vault source: %w", err)
+		}
+		source = &vault
+	case SourceEncryptedFile:
+		var encryptedFile EncryptedFile
+		if err := decoder.Decode(&encryptedFile); err != nil {
+			return nil, fmt.Errorf("unmarshalling encrypted_file source: %w", err)
+		}
+		source = &encryptedFile
+	case SourceOIDC:
+		var oidc OIDC
+		if err := decoder.Decode(&oidc); err != nil {
+			return nil, fmt.Errorf("unmarshalling oidc source: %w", err)
+		}
+		source = &oidc
+	case SourceOAuth2:
+		var oauth2 OAuth2
+		if err := decoder.Decode(&oauth2); err != nil {
+			return nil, fmt.Errorf("unmarshalling oauth2 source: %w", err)
+		}
+		source = &oauth2
+	case SourceSystemdCredential:
+		var systemdCredential SystemdCredential
+		if err := decoder.Decode(&systemdCredential); err != nil {
+			return nil, fmt.Errorf("unmarshalling systemd_credential source: %w", err)
+		}
+		source = &systemdCredential
+	case SourceEncryptedBundle:
+		var encryptedBundle EncryptedBundle
+		if err := decoder.Decode(&encryptedBundle); err != nil {
+			return nil, fmt.Errorf("unmarshalling encrypted_bundle source: %w", err)
+		}
+		source = &encryptedBundle
+	case SourceTemplate:
+		var template Template
+		if err := decoder.Decode(&template); err != nil {
+			return nil, fmt.Errorf("unmarshalling template source: %w", err)
+		}
+		template.chain = c
+		source = &template
 	default:
 		return nil, fmt.Errorf("unknown source %q", entry.Source)
 	}
@@ -125,6 +217,25 @@ func (c *LookupChain) sourceFor(entry ConfigEntry) (Source, error) {
 	return source, nil
 }
 
+// interactiveSources are the sources skipped while the CI preset is active.
+// They rely on a user session (a system keyring, a browser or git credential helpers) that CI jobs don't have.
+var interactiveSources = []string{SourceKeyring, SourceOAuth2, SourceGitCredential}
+
+// skip returns true for entries whose when clause doesn't hold.
+// While the CI preset is active, it also skips interactiveSources,
+// unless the entry explicitly opts in with a when clause.
+func skip(entry ConfigEntry) bool {
+	if !entry.When.Holds() {
+		logging.Debugf("skipping %s source: when clause doesn't hold", entry.Source)
+		return true
+	}
+	if len(os.Getenv(api.CIPresetEnv)) == 0 || entry.When != nil || !slices.Contains(interactiveSources, entry.Source) {
+		return false
+	}
+	logging.Debugf("skipping interactive %s source in CI (add a when clause to use it)", entry.Source)
+	return true
+}
+
 type Config []ConfigEntry
 
 // ConfigEntry is a single entry in the lookup chain.
@@ -132,15 +243,22 @@ type Config []ConfigEntry
 type ConfigEntry struct {
 	// Source is the name of the source used to look up the secret.
 	Source string `json:"source"`
+	// Transform is a pipeline of transforms applied to the value found by the source,
+	// like ["json:token", "prefix:Bearer "]. It is supported by every source.
+	Transform []string `json:"transform,omitempty"`
+	// When is an optional condition. If it doesn't hold, the entry is skipped.
+	When *Condition `json:"when,omitempty"`
 	json.RawMessage
 }
 
 func (c *ConfigEntry) UnmarshalJSON(data []byte) error {
-	// Use special type to learn the source field.
+	// Use special type to learn the fields shared by all sources.
 	// This is necessary because the embedded json.RawMessage
 	// is greedy and will consume the entire input.
 	type SourceConfigEntry struct {
-		Source string `json:"source"`
+		Source    string          `json:"source"`
+		Transform []string        `json:"transform"`
+		When      json.RawMessage `json:"when"`
 	}
 	var entry SourceConfigEntry
 	if err := json.Unmarshal(data, &entry); err != nil {
@@ -150,7 +268,29 @@ func (c *ConfigEntry) UnmarshalJSON(data []byte) error {
 		return errors.New("source must be set")
 	}
 	c.Source = entry.Source
+	c.Transform = entry.Transform
+	if entry.When != nil {
+		decoder := json.NewDecoder(bytes.NewReader(entry.When))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&c.When); err != nil {
+			return fmt.Errorf("unmarshalling when clause: %w", err)
+		}
+	}
 	c.RawMessage = data
+	if entry.Transform != nil || entry.When != nil {
+		// remove the shared fields, so that sources can reject unknown fields
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return err
+		}
+		delete(fields, "transform")
+		delete(fields, "when")
+		raw, err := json.Marshal(fields)
+		if err != nil {
+			return err
+		}
+		c.RawMessage = raw
+	}
 	return nil
 }
 
@@ -204,6 +344,8 @@ func (e *Env) SetupInstructions(binding string) (string, bool) {
 	return fmt.Sprintf(" - Export the environment variable %s (status: %s)", e.Name, status), true
 }
 
+// Keyring looks up a secret in the system keyring.
+// If no system keyring is available, the encrypted file secret store is used instead.
 type Keyring struct {
 	// Source is the name of the source used to look up the secret.
 	// It must be "keyring".
@@ -219,8 +361,12 @@ func (k *Keyring) Lookup(binding string) (string, error) {
 	if k.Binding != binding {
 		return "", &NotFoundErr{}
 	}
-	val, err := keyring.Get(k.Service, "")
-	if errors.Is(err, keyring.ErrNotFound) {
+	store, err := secretstore.Open(secretstore.StoreAuto)
+	if err != nil {
+		return "", err
+	}
+	val, err := store.Get(k.Service)
+	if errors.Is(err, secretstore.ErrNotFound) {
 		return "", &NotFoundErr{reason: err.Error()}
 	}
 	if err != nil {
@@ -240,9 +386,13 @@ func (e *Keyring) SetupInstructions(binding string) (string, bool) {
 	if e.Binding != binding {
 		return "", false
 	}
-	_, getErr := keyring.Get(e.Service, "")
+	store, err := secretstore.Open(secretstore.StoreAuto)
+	if err != nil {
+		return "", false
+	}
+	_, getErr := store.Get(e.Service)
 	var status string
-	if errors.Is(getErr, keyring.ErrNotFound) {
+	if errors.Is(getErr, secretstore.ErrNotFound) {
 		status = "NOT SET"
 	} else if getErr != nil {
 		status = "ERROR ACCESSING KEYCHAIN"
@@ -250,8 +400,8 @@ func (e *Keyring) SetupInstructions(binding string) (string, bool) {
 		status = "SET"
 	}
 
-	return fmt.Sprintf(` - Add the secret to the system keyring under the %s service name (status: %s):
-    $ %s setup-keyring -f secret.txt %s`, e.Service, status, os.Args[0], e.Service), true
+	return fmt.Sprintf(` - Add the secret to the %s under the %s service name (status: %s):
+    $ %s setup-keyring -f secret.txt %s`, store.Name(), e.Service, status, os.Args[0], e.Service), true
 }
 
 type Static struct {
@@ -313,8 +463,14 @@ type Google struct {
 }
 
 func (g *Google) Lookup(binding string) (string, error) {
+	result, err := g.LookupResult(binding)
+	return result.Value, err
+}
+
+// LookupResult returns the token together with its expiry.
+func (g *Google) LookupResult(binding string) (Result, error) {
 	if g.Binding != binding {
-		return "", &NotFoundErr{}
+		return Result{}, &NotFoundErr{}
 	}
 
 	ctx := context.Background()
@@ -323,85 +479,89 @@ func (g *Google) Lookup(binding string) (string, error) {
 	case "", "access":
 		creds, err := gauth.FindDefaultCredentials(ctx, g.Scopes...)
 		if err != nil {
-			return "", fmt.Errorf("failed to find default credentials: %w", err)
+			return Result{}, fmt.Errorf("failed to find default credentials: %w", err)
 		}
 		token, err := creds.TokenSource.Token()
 		if err != nil {
-			return "", fmt.Errorf("failed to get access token: %w", err)
+			return Result{}, fmt.Errorf("failed to get access token: %w", err)
 		}
-		return "Bearer " + token.AccessToken, nil
+		return Result{Value: "Bearer " + token.AccessToken, Expires: token.Expiry}, nil
 
 	case "id", "jwt":
 		creds, ferr := gauth.FindDefaultCredentials(ctx)
 		if ferr != nil {
-			return "", fmt.Errorf("failed to find default credentials for id token: %w", ferr)
+			return Result{}, fmt.Errorf("failed to find default credentials for id token: %w", ferr)
 		}
 
 		// If audience is provided, use the standard idtoken approach
 		if g.Audience != "" {
 			ts, err := idtoken.NewTokenSource(ctx, g.Audience, option.WithCredentials(creds))
 			if err != nil {
-				return "", fmt.Errorf("failed to create id token source: %w", err)
+				return Result{}, fmt.Errorf("failed to create id token source: %w", err)
 			}
 			tok, err := ts.Token()
 			if err != nil {
-				return "", fmt.Errorf("failed to mint id token: %w", err)
+				return Result{}, fmt.Errorf("failed to mint id token: %w", err)
 			}
 			// tok.AccessToken holds the JWT (ID token).
-			return "Bearer " + tok.AccessToken, nil
+			return Result{Value: "Bearer " + tok.AccessToken, Expires: tok.Expiry}, nil
 		}
 
 		// Alternative flow: use data from application_default_credentials.json
 		// to mint an ID token.
 		// This is not documented officially, but sometimes this is the only option available.
 		if creds.JSON == nil {
-			return "", fmt.Errorf("no JSON credentials available for JWT config")
+			return Result{}, fmt.Errorf("no JSON credentials available for JWT config")
 		}
 
 		var requestBody map[string]any
 		if err := json.Unmarshal(creds.JSON, &requestBody); err != nil {
-			return "", fmt.Errorf("failed to unmarshal JSON credentials: %w", err)
+			return Result{}, fmt.Errorf("failed to unmarshal JSON credentials: %w", err)
 		}
 		requestBody["grant_type"] = "refresh_token"
 
 		// Send the request to the Google OAuth2 token endpoint
 		jsonBody, err := json.Marshal(requestBody)
 		if err != nil {
-			return "", fmt.Errorf("failed to marshal request body: %w", err)
+			return Result{}, fmt.Errorf("failed to marshal request body: %w", err)
 		}
 
 		req, err := http.NewRequest("POST", "https://oauth2.googleapis.com/token", bytes.NewBuffer(jsonBody))
 		if err != nil {
-			return "", fmt.Errorf("failed to create request: %w", err)
+			return Result{}, fmt.Errorf("failed to create request: %w", err)
 		}
 		req.Header.Set("Content-Type", "application/json")
 
 		client := &http.Client{Timeout: 30 * time.Second}
 		resp, err := client.Do(req)
 		if err != nil {
-			return "", fmt.Errorf("failed to send request to token endpoint: %w", err)
+			return Result{}, fmt.Errorf("failed to send request to token endpoint: %w", err)
 		}
 		defer resp.Body.Close()
 
 		if resp.StatusCode != http.StatusOK {
 			body, _ := io.ReadAll(resp.Body)
-			return "", fmt.Errorf("token endpoint returned status %d: %s", resp.StatusCode, string(body))
+			return Result{}, fmt.Errorf("token endpoint returned status %d: %s", resp.StatusCode, string(body))
 		}
 
 		var tokenResponse GoogleTokenResponse
 
 		if err := json.NewDecoder(resp.Body).Decode(&tokenResponse); err != nil {
-			return "", fmt.Errorf("failed to decode token response: %w", err)
+			return Result{}, fmt.Errorf("failed to decode token response: %w", err)
 		}
 
 		if tokenResponse.IdentityToken == "" {
-			return "", fmt.Errorf("token response does not contain an identity token")
+			return Result{}, fmt.Errorf("token response does not contain an identity token")
 		}
 
-		return "Bearer " + tokenResponse.IdentityToken, nil
+		result := Result{Value: "Bearer " + tokenResponse.IdentityToken}
+		if tokenResponse.ExpiresIn > 0 {
+			result.Expires = time.Now().Add(time.Duration(tokenResponse.ExpiresIn) * time.Second)
+		}
+		return result, nil
 
 	default:
-		return "", fmt.Errorf(`google: unknown token_type %q (want "access" or "id")`, g.TokenType)
+		return Result{}, fmt.Errorf(`google: unknown token_type %q (want "access" or "id")`, g.TokenType)
 	}
 }
 
@@ -0,0 +1,593 @@
+package lookupchain
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tweag/credential-helper/api"
+	"github.com/tweag/credential-helper/secretstore"
+)
+
+func TestFileSource(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "token")
+	assert.NoError(t, os.WriteFile(plain, []byte("secret\n\n"), 0o600))
+	document := filepath.Join(dir, "token.json")
+	assert.NoError(t, os.WriteFile(document, []byte(`{"data": {"token": "from-json"}}`), 0o644))
+
+	chain := New(Default([]Source{
+		&File{Path: filepath.Join(dir, "missing")},
+		&File{Path: document, JSONField: "data.token", Binding: "json"},
+		&File{Path: plain},
+	}))
+	value, err := chain.Lookup("default")
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", value)
+	value, err = chain.Lookup("json")
+	assert.NoError(t, err)
+	assert.Equal(t, "from-json", value)
+
+	missingField := &File{Path: document, JSONField: "data.other"}
+	missingField.Canonicalize()
+	_, err = missingField.Lookup("default")
+	assert.True(t, IsNotFoundErr(err))
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+	strict := &File{Path: document, StrictPermissions: true}
+	strict.Canonicalize()
+	_, err = strict.Lookup("default")
+	assert.ErrorContains(t, err, "too open")
+}
+
+func TestCommandSource(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a POSIX shell")
+	}
+	counter := filepath.Join(t.TempDir(), "invocations")
+	script := `echo run >> "$1"; echo '{"id": "key-id", "secret": "key-secret"}'`
+
+	chain := New(Default([]Source{
+		&Command{Command: []string{"sh", "-c", script, "sh", counter}, JSONField: "id", Binding: "id"},
+		&Command{Command: []string{"sh", "-c", script, "sh", counter}, JSONField: "secret", Binding: "secret"},
+		&Command{Command: []string{"sh", "-c", "exit 1"}, OnFailure: CommandOnFailureNotFound, Binding: "other"},
+		&Command{Command: []string{"sh", "-c", "exit 2"}, Binding: "broken"},
+	}))
+	value, err := chain.Lookup("id")
+	assert.NoError(t, err)
+	assert.Equal(t, "key-id", value)
+	value, err = chain.Lookup("secret")
+	assert.NoError(t, err)
+	assert.Equal(t, "key-secret", value)
+
+	invocations, err := os.ReadFile(counter)
+	assert.NoError(t, err)
+	assert.Equal(t, "run\n", string(invocations))
+
+	_, err = chain.Lookup("other")
+	assert.True(t, IsNotFoundErr(err))
+	_, err = chain.Lookup("broken")
+	assert.Error(t, err)
+	assert.False(t, IsNotFoundErr(err))
+
+	// the same command runs once, even if it is prefetched concurrently for several bindings
+	slow := `sleep 0.2; echo run >> "$1"; echo "$2"`
+	chain = New(Default([]Source{
+		&Command{Command: []string{"sh", "-c", slow, "sh", counter, "a"}, Binding: "a"},
+		&Command{Command: []string{"sh", "-c", slow, "sh", counter, "b"}, Binding: "b"},
+		&Command{Command: []string{"sh", "-c", slow, "sh", counter, "a"}, Binding: "also_a"},
+	}))
+	chain.Prefetch("a", "b", "also_a")
+	value, err = chain.Lookup("also_a")
+	assert.NoError(t, err)
+	assert.Equal(t, "a", value)
+	invocations, err = os.ReadFile(counter)
+	assert.NoError(t, err)
+	assert.Equal(t, "run\nrun\nrun\n", string(invocations))
+}
+
+func TestNetrcSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "netrc")
+	assert.NoError(t, os.WriteFile(path, []byte(`# comment
+machine files.acme.corp
+  login alice
+  password s3cret
+macdef init
+  cd /pub
+
+machine other.acme.corp login bob password hunter2
+default login anonymous password guest
+`), 0o600))
+
+	// lookup chains memoize results, so every request uses a new chain
+	chain := New(Default([]Source{
+		&Netrc{Path: path, Field: NetrcFieldLogin, Binding: "login"},
+		&Netrc{Path: path},
+	}))
+	t.Setenv(api.RequestURIEnv, "https://files.acme.corp/artifact.tar.gz")
+	login, err := chain.Lookup("login")
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", login)
+	password, err := chain.Lookup("default")
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cret", password)
+
+	t.Setenv(api.RequestURIEnv, "https://OTHER.acme.corp/")
+	password, err = New(chain.config).Lookup("default")
+	assert.NoError(t, err)
+	assert.Equal(t, "hunter2", password)
+
+	t.Setenv(api.RequestURIEnv, "https://unknown.example.com/")
+	login, err = New(chain.config).Lookup("login")
+	assert.NoError(t, err)
+	assert.Equal(t, "anonymous", login)
+}
+
+func TestGitCredentialSource(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil || runtime.GOOS == "windows" {
+		t.Skip("requires git and a POSIX shell")
+	}
+	// configure a git credential helper that only knows credentials for files.acme.corp
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "gitconfig"))
+	t.Setenv("GIT_CONFIG_COUNT", "1")
+	t.Setenv("GIT_CONFIG_KEY_0", "credential.helper")
+	actions := filepath.Join(t.TempDir(), "actions")
+	t.Setenv("GIT_CREDENTIAL_ACTIONS", actions)
+	t.Setenv("GIT_CONFIG_VALUE_0", `!f() { echo "$1" >> "$GIT_CREDENTIAL_ACTIONS"; test "$1" = get || exit 0; grep -q host=files.acme.corp && printf 'username=alice\npassword=token\n'; }; f`)
+
+	chain := New(Default([]Source{
+		&GitCredential{Field: GitCredentialFieldUsername, Binding: "username"},
+		&GitCredential{},
+	}))
+	t.Setenv(api.RequestURIEnv, "https://files.acme.corp/artifact.tar.gz")
+	username, err := chain.Lookup("username")
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", username)
+	password, err := chain.Lookup("default")
+	assert.NoError(t, err)
+	assert.Equal(t, "token", password)
+	// the credential is filled once and not approved by default
+	content, err := os.ReadFile(actions)
+	assert.NoError(t, err)
+	assert.Equal(t, "get\n", string(content))
+
+	var out strings.Builder
+	assert.NoError(t, chain.Logout(&out))
+	assert.Contains(t, out.String(), "Rejected the git credential for https://files.acme.corp/artifact.tar.gz")
+	content, err = os.ReadFile(actions)
+	assert.NoError(t, err)
+	assert.Equal(t, "get\nerase\n", string(content))
+
+	approving := New(Default([]Source{&GitCredential{Approve: true}}))
+	password, err = approving.Lookup("default")
+	assert.NoError(t, err)
+	assert.Equal(t, "token", password)
+	content, err = os.ReadFile(actions)
+	assert.NoError(t, err)
+	// the output of git credential fill is memoized for the process, so only the approval runs
+	assert.Equal(t, "get\nerase\nstore\n", string(content))
+
+	t.Setenv(api.RequestURIEnv, "https://other.acme.corp/artifact.tar.gz")
+	_, err = New(chain.config).Lookup("default")
+	assert.True(t, IsNotFoundErr(err), err)
+}
+
+func TestVaultSource(t *testing.T) {
+	var reads int
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/auth/approle/login", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		if body["role_id"] != "ci" || body["secret_id"] != "approle-secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"auth": {"client_token": "approle-token", "lease_duration": 3600}}`)
+	})
+	mux.HandleFunc("GET /v1/kv/data/ci/artifacts", func(w http.ResponseWriter, r *http.Request) {
+		reads++
+		assert.Equal(t, "platform", r.Header.Get("X-Vault-Namespace"))
+		if r.Header.Get("X-Vault-Token") != "approle-token" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"errors": ["permission denied"]}`)
+			return
+		}
+		fmt.Fprint(w, `{"lease_duration": 0, "data": {"data": {"user": "robot", "token": "vault-secret"}}}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	t.Setenv("VAULT_SECRET_ID", "approle-secret")
+	auth := VaultAuth{Method: VaultAuthAppRole, RoleID: "ci", SecretIDEnv: "VAULT_SECRET_ID"}
+	chain := New(Default([]Source{
+		&Vault{Address: server.URL, Namespace: "platform", Mount: "kv", Path: "ci/artifacts", Field: "user", Auth: auth, Binding: "username"},
+		&Vault{Address: server.URL, Namespace: "platform", Mount: "kv", Path: "ci/artifacts", Field: "token", Auth: auth},
+		&Vault{Address: server.URL, Namespace: "platform", Mount: "kv", Path: "ci/missing", Field: "token", Auth: auth, Binding: "missing"},
+	}))
+	username, err := chain.Lookup("username")
+	assert.NoError(t, err)
+	assert.Equal(t, "robot", username)
+	token, err := chain.Lookup("default")
+	assert.NoError(t, err)
+	assert.Equal(t, "vault-secret", token)
+	assert.Equal(t, 1, reads)
+
+	_, err = chain.Lookup("missing")
+	assert.True(t, IsNotFoundErr(err), err)
+
+	// a source with another auth config doesn't share the memoized secret
+	t.Setenv("VAULT_TOKEN", "wrong-token")
+	forbidden := &Vault{Address: server.URL, Namespace: "platform", Mount: "kv", Path: "ci/artifacts", Field: "token"}
+	forbidden.Canonicalize()
+	_, err = forbidden.Lookup("default")
+	assert.ErrorContains(t, err, "permission denied")
+}
+
+func TestVaultConcurrentReads(t *testing.T) {
+	// each read waits until both reads arrived, which only works if they are not serialized
+	arrived := make(chan struct{})
+	var once sync.Once
+	var waiting atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/secret/data/", func(w http.ResponseWriter, r *http.Request) {
+		if waiting.Add(1) == 2 {
+			once.Do(func() { close(arrived) })
+		}
+		select {
+		case <-arrived:
+		case <-time.After(10 * time.Second):
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, `{"data": {"data": {"token": %q}}}`, path.Base(r.URL.Path))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	t.Setenv("VAULT_TOKEN", "token")
+	chain := New(Default([]Source{
+		&Vault{Address: server.URL, Mount: "secret", Path: "concurrent/first", Field: "token", Binding: "first"},
+		&Vault{Address: server.URL, Mount: "secret", Path: "concurrent/second", Field: "token", Binding: "second"},
+	}))
+	chain.Prefetch("first", "second")
+	first, err := chain.Lookup("first")
+	assert.NoError(t, err)
+	assert.Equal(t, "first", first)
+	second, err := chain.Lookup("second")
+	assert.NoError(t, err)
+	assert.Equal(t, "second", second)
+}
+
+func TestLookupResult(t *testing.T) {
+	t.Setenv("LOOKUPCHAIN_TEST_TOKEN", "from-env")
+	chain := New(Default([]Source{
+		&Env{Name: "LOOKUPCHAIN_TEST_MISSING"},
+		&Env{Name: "LOOKUPCHAIN_TEST_TOKEN"},
+	}))
+	result, err := chain.LookupResult("default")
+	assert.NoError(t, err)
+	assert.Equal(t, "from-env", result.Value)
+	assert.Equal(t, "env (lookup_chain[1])", result.Provenance)
+	assert.True(t, result.Expires.IsZero())
+
+	soon := time.Now().Add(time.Minute)
+	later := soon.Add(time.Hour)
+	assert.Equal(t, soon, EarliestExpiry(Result{Expires: later}, Result{}, Result{Expires: soon}))
+	assert.True(t, EarliestExpiry(Result{}).IsZero())
+}
+
+func TestTransformsAndTemplates(t *testing.T) {
+	var config Config
+	err := json.Unmarshal([]byte(`[
+  {"source": "static", "name": "{\"user\": \"robot\", \"token\": \"t0k3n\"}", "binding": "blob"},
+  {"source": "static", "name": "{\"user\": \"robot\", \"token\": \"t0k3n\"}", "transform": ["json:token"], "binding": "token"},
+  {"source": "template", "template": "{{ blob | json:user }}:{{token}}", "transform": ["base64", "prefix:'Basic '"]},
+  {"source": "template", "template": "{{ token | prefix:'Bearer |' | suffix:\"}}\" }}", "binding": "quoted"},
+  {"source": "template", "template": "{{ loop }}", "binding": "loop"}
+]`), &config)
+	assert.NoError(t, err)
+	chain := New(config)
+
+	value, err := chain.Lookup("token")
+	assert.NoError(t, err)
+	assert.Equal(t, "t0k3n", value)
+
+	value, err = chain.Lookup("default")
+	assert.NoError(t, err)
+	assert.Equal(t, "Basic cm9ib3Q6dDBrM24=", value)
+
+	value, err = chain.Lookup("quoted")
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer |t0k3n}}", value)
+
+	_, err = chain.Lookup("loop")
+	assert.ErrorContains(t, err, "references itself")
+
+	bindings, err := References("{{ blob | json:user }}:{{token}} {{ token | prefix:'}}' }}")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"blob", "token"}, bindings)
+	_, err = References("{{ token")
+	assert.ErrorContains(t, err, "unterminated")
+}
+
+func TestWhen(t *testing.T) {
+	t.Setenv("CI", "true")
+	t.Setenv("CI_TOKEN", "from-ci")
+	t.Setenv("LAPTOP_TOKEN", "from-laptop")
+	t.Setenv(api.CIProviderEnv, "GitHub Actions")
+	// conditions only depend on the detected provider, not on the CI preset
+	t.Setenv(api.CIPresetEnv, "")
+	t.Setenv(api.ProfileEnv, "")
+
+	var config Config
+	assert.NoError(t, json.Unmarshal([]byte(fmt.Sprintf(`[
+		{"source": "env", "name": "LAPTOP_TOKEN", "when": {"ci": false}},
+		{"source": "env", "name": "OTHER_OS", "when": {"not": {"os": [%q]}}},
+		{"source": "env", "name": "CI_TOKEN", "when": {"env_equals": {"CI": "true"}, "ci_provider": ["github actions"]}, "transform": ["prefix:'Bearer '"]}
+	]`, runtime.GOOS)), &config))
+	chain := New(config)
+	value, err := chain.Lookup("default")
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer from-ci", value)
+	instructions := chain.SetupInstructions("default", "token")
+	assert.Contains(t, instructions, "CI_TOKEN")
+	assert.NotContains(t, instructions, "LAPTOP_TOKEN")
+
+	t.Setenv(api.CIProviderEnv, "")
+	value, err = New(chain.config).Lookup("default")
+	assert.NoError(t, err)
+	assert.Equal(t, "from-laptop", value)
+
+	assert.False(t, (&Condition{Profile: []string{"work"}, Not: &Condition{EnvSet: []string{"UNSET_VARIABLE"}}}).Holds())
+	t.Setenv(api.ProfileEnv, "work")
+	assert.True(t, (&Condition{Profile: []string{"work"}, Not: &Condition{EnvSet: []string{"UNSET_VARIABLE"}}}).Holds())
+
+	assert.Error(t, json.Unmarshal([]byte(`[{"source": "env", "name": "X", "when": {"os_name": "linux"}}]`), &config))
+}
+
+func TestCIPreset(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "token")
+	assert.NoError(t, os.WriteFile(file, []byte("from-file"), 0o600))
+	t.Setenv("CI_TOKEN", "from-env")
+	t.Setenv(api.CIProviderEnv, "GitHub Actions")
+	t.Setenv(api.CIPresetEnv, "1")
+
+	var config Config
+	assert.NoError(t, json.Unmarshal([]byte(fmt.Sprintf(`[
+		{"source": "keyring", "service": "tweag-credential-helper:test"},
+		{"source": "file", "path": %q},
+		{"source": "env", "name": "CI_TOKEN"},
+		{"source": "env", "name": "CI_TOKEN", "binding": "non_interactive"},
+		{"source": "git-credential", "url": "https://example.com", "binding": "opt_in", "when": {"ci": true}},
+		{"source": "static", "name": "fallback", "binding": "opt_in"}
+	]`, file)), &config))
+
+	// interactive sources are skipped in CI, other sources like file are still used
+	value, err := New(config).Lookup("default")
+	assert.NoError(t, err)
+	assert.Equal(t, "from-file", value)
+	instructions := New(config).SetupInstructions("default", "token")
+	assert.Contains(t, instructions, file)
+	assert.NotContains(t, instructions, "keyring")
+	value, err = New(config).Lookup("non_interactive")
+	assert.NoError(t, err)
+	assert.Equal(t, "from-env", value)
+
+	// interactive sources with a when clause opt in
+	assert.True(t, skip(config[0]))
+	assert.False(t, skip(config[4]))
+
+	t.Setenv(api.CIPresetEnv, "")
+	assert.False(t, skip(config[0]))
+}
+
+func TestMemoizedLookups(t *testing.T) {
+	dir := t.TempDir()
+	username := filepath.Join(dir, "username")
+	password := filepath.Join(dir, "password")
+	assert.NoError(t, os.WriteFile(username, []byte("user"), 0o600))
+	assert.NoError(t, os.WriteFile(password, []byte("secret"), 0o600))
+
+	chain := New(Default([]Source{
+		// a template doesn't stop prefetching the following entries
+		&Template{Template: "{{username}}:{{password}}", Binding: "basic"},
+		&File{Path: filepath.Join(dir, "missing"), Binding: "username"},
+		&File{Path: username, Binding: "username"},
+		&File{Path: password, Binding: "password"},
+	}))
+	chain.Prefetch("username", "password", "basic", "unknown")
+
+	// the sources are not queried again, so removing the files has no effect
+	assert.NoError(t, os.Remove(username))
+	assert.NoError(t, os.Remove(password))
+	value, err := chain.Lookup("username")
+	assert.NoError(t, err)
+	assert.Equal(t, "user", value)
+	value, err = chain.Lookup("basic")
+	assert.NoError(t, err)
+	assert.Equal(t, "user:secret", value)
+	_, err = chain.Lookup("unknown")
+	assert.True(t, IsNotFoundErr(err))
+
+	// a new chain queries the sources again
+	_, err = New(chain.config).Lookup("username")
+	assert.True(t, IsNotFoundErr(err))
+}
+
+func TestOIDCSource(t *testing.T) {
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	idToken := "eyJhbGciOiJub25lIn0." + base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp": %d}`, expiry.Unix()))) + ".sig"
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /token", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer request-token", r.Header.Get("Authorization"))
+		assert.Equal(t, "1.0", r.URL.Query().Get("api-version"))
+		assert.Equal(t, "artifacts.acme.corp", r.URL.Query().Get("audience"))
+		fmt.Fprintf(w, `{"value": %q}`, idToken)
+	})
+	mux.HandleFunc("POST /sts", func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, "urn:ietf:params:oauth:grant-type:token-exchange", r.PostForm.Get("grant_type"))
+		assert.Equal(t, "urn:ietf:params:oauth:token-type:jwt", r.PostForm.Get("subject_token_type"))
+		if r.PostForm.Get("subject_token") != idToken {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error": "invalid_grant", "error_description": "bad subject token"}`)
+			return
+		}
+		assert.Equal(t, "read write", r.PostForm.Get("scope"))
+		fmt.Fprint(w, `{"access_token": "exchanged", "issued_token_type": "urn:ietf:params:oauth:token-type:access_token", "token_type": "Bearer", "expires_in": 300}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	t.Setenv("ACTIONS_ID_TOKEN_REQUEST_URL", server.URL+"/token?api-version=1.0")
+	t.Setenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN", "request-token")
+	chain := New(Default([]Source{
+		&OIDC{Audience: "artifacts.acme.corp", Binding: "id_token"},
+		&OIDC{Audience: "artifacts.acme.corp", Exchange: &OIDCExchange{TokenURL: server.URL + "/sts", Scopes: []string{"read", "write"}}},
+	}))
+	result, err := chain.LookupResult("id_token")
+	assert.NoError(t, err)
+	assert.Equal(t, idToken, result.Value)
+	assert.Equal(t, expiry, result.Expires)
+	before := time.Now()
+	result, err = chain.LookupResult("default")
+	assert.NoError(t, err)
+	assert.Equal(t, "exchanged", result.Value)
+	assert.WithinDuration(t, before.Add(5*time.Minute), result.Expires, 5*time.Second)
+
+	// GitLab exposes the id token as an environment variable
+	t.Setenv("ACTIONS_ID_TOKEN_REQUEST_URL", "")
+	t.Setenv("GITLAB_CI", "true")
+	t.Setenv("GITLAB_ID_TOKEN", "not-a-jwt")
+	gitlab := &OIDC{TokenEnv: "GITLAB_ID_TOKEN", Exchange: &OIDCExchange{TokenURL: server.URL + "/sts"}}
+	gitlab.Canonicalize()
+	_, err = gitlab.Lookup("default")
+	assert.ErrorContains(t, err, "bad subject token")
+
+	t.Setenv("GITLAB_CI", "")
+	_, err = New(chain.config).Lookup("default")
+	assert.True(t, IsNotFoundErr(err), err)
+}
+
+func TestOAuth2Source(t *testing.T) {
+	t.Setenv(api.SecretStoreFileEnv, filepath.Join(t.TempDir(), "secrets.enc"))
+	t.Setenv(api.SecretStorePassphraseEnv, "test")
+
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"issuer": %q, "device_authorization_endpoint": "%s/device", "token_endpoint": "%s/token"}`, server.URL, server.URL, server.URL)
+	})
+	mux.HandleFunc("POST /device", func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, "cli", r.PostForm.Get("client_id"))
+		assert.Equal(t, "openid offline_access", r.PostForm.Get("scope"))
+		fmt.Fprint(w, `{"device_code": "device", "user_code": "ABCD-EFGH", "verification_uri": "https://idp.acme.corp/activate", "expires_in": 60, "interval": 1}`)
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.PostForm.Get("device_code") == "device":
+			fmt.Fprint(w, `{"access_token": "access-1", "token_type": "Bearer", "refresh_token": "refresh-1", "expires_in": 600}`)
+		case r.PostForm.Get("refresh_token") == "refresh-1":
+			// the refresh token is rotated
+			fmt.Fprint(w, `{"access_token": "access-2", "token_type": "Bearer", "refresh_token": "refresh-2", "expires_in": 600}`)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error": "invalid_grant"}`)
+		}
+	})
+	server = httptest.NewServer(mux)
+	defer server.Close()
+
+	source := &OAuth2{Issuer: server.URL, ClientID: "cli", Store: "file"}
+	chain := New(Default([]Source{source}))
+	_, err := chain.Lookup("default")
+	assert.True(t, IsNotFoundErr(err), err)
+
+	var out strings.Builder
+	assert.NoError(t, New(chain.config).Login(context.Background(), &out))
+	assert.Contains(t, out.String(), "ABCD-EFGH")
+
+	before := time.Now()
+	result, err := New(chain.config).LookupResult("default")
+	assert.NoError(t, err)
+	assert.Equal(t, "access-2", result.Value)
+	assert.WithinDuration(t, before.Add(10*time.Minute), result.Expires, 5*time.Second)
+
+	// the rotated refresh token was stored, so the old one is not used anymore
+	_, err = New(chain.config).Lookup("default")
+	assert.ErrorContains(t, err, "invalid_grant")
+
+	assert.NoError(t, New(chain.config).Logout(&out))
+	assert.Contains(t, out.String(), "Deleted refresh token")
+	_, err = New(chain.config).Lookup("default")
+	assert.True(t, IsNotFoundErr(err), err)
+}
+
+func TestSystemdCredentialSource(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "artifact-token"), []byte("from-systemd\n"), 0o400))
+
+	chain := New(Default([]Source{
+		&SystemdCredential{Name: "missing"},
+		&SystemdCredential{Name: "artifact-token"},
+	}))
+	t.Setenv("CREDENTIALS_DIRECTORY", "")
+	_, err := chain.Lookup("default")
+	assert.True(t, IsNotFoundErr(err), err)
+
+	t.Setenv("CREDENTIALS_DIRECTORY", dir)
+	value, err := New(chain.config).Lookup("default")
+	assert.NoError(t, err)
+	assert.Equal(t, "from-systemd", value)
+
+	invalid := &SystemdCredential{Name: "../artifact-token"}
+	invalid.Canonicalize()
+	_, err = invalid.Lookup("default")
+	assert.ErrorContains(t, err, "invalid systemd credential name")
+}
+
+func TestEncryptedBundleSource(t *testing.T) {
+	identity, err := secretstore.GenerateIdentity()
+	assert.NoError(t, err)
+	bundle, err := secretstore.NewBundle([]string{identity.Recipient()})
+	assert.NoError(t, err)
+	bundle.Set("artifacts-token", "from-bundle")
+	workspace := t.TempDir()
+	assert.NoError(t, bundle.WriteFile(filepath.Join(workspace, ".tweag-credential-helper.bundle")))
+	t.Setenv(api.WorkspaceEnv, workspace)
+
+	chain := New(Default([]Source{
+		&EncryptedBundle{Key: "missing", IdentityEnv: "TEST_BUNDLE_IDENTITY", IdentityFile: "/nonexistent"},
+		&EncryptedBundle{Key: "artifacts-token", IdentityEnv: "TEST_BUNDLE_IDENTITY", IdentityFile: "/nonexistent"},
+	}))
+	t.Setenv("TEST_BUNDLE_IDENTITY", identity.String())
+	value, err := chain.Lookup("default")
+	assert.NoError(t, err)
+	assert.Equal(t, "from-bundle", value)
+
+	other, err := secretstore.GenerateIdentity()
+	assert.NoError(t, err)
+	t.Setenv("TEST_BUNDLE_IDENTITY", other.String())
+	_, err = New(chain.config).Lookup("default")
+	assert.ErrorContains(t, err, "not a recipient")
+}
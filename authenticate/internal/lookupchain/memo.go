@@ -0,0 +1,128 @@
+package lookupchain
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tweag/credential-helper/logging"
+)
+
+// session memoizes the sources of a lookup chain and their results.
+// A lookup chain lives for a single request, so each entry is decoded once
+// and queried at most once per binding, no matter how many bindings a helper looks up.
+type session struct {
+	mu      sync.Mutex
+	sources map[int]Source
+	results map[resultKey]*memoizedResult
+}
+
+type resultKey struct {
+	index   int
+	binding string
+}
+
+type memoizedResult struct {
+	done   chan struct{}
+	result Result
+	err    error
+}
+
+// source returns the decoded source of the i-th entry.
+func (c *LookupChain) source(i int) (Source, error) {
+	c.session.mu.Lock()
+	defer c.session.mu.Unlock()
+	if source, ok := c.session.sources[i]; ok {
+		return source, nil
+	}
+	source, err := c.sourceFor(c.config[i])
+	if err != nil {
+		return nil, err
+	}
+	if c.session.sources == nil {
+		c.session.sources = make(map[int]Source)
+	}
+	c.session.sources[i] = source
+	return source, nil
+}
+
+// resolve looks up a binding in the i-th entry and applies its transforms.
+// Concurrent and repeated calls for the same entry and binding share a single lookup.
+// Templates are not memoized, since they are cheap and a cyclic template would wait for itself.
+func (c *LookupChain) resolve(i int, source Source, binding string) (Result, error) {
+	if c.config[i].Source == SourceTemplate {
+		return c.resolveUncached(i, source, binding)
+	}
+	key := resultKey{index: i, binding: binding}
+	c.session.mu.Lock()
+	memoized, ok := c.session.results[key]
+	if !ok {
+		memoized = &memoizedResult{done: make(chan struct{})}
+		if c.session.results == nil {
+			c.session.results = make(map[resultKey]*memoizedResult)
+		}
+		c.session.results[key] = memoized
+	}
+	c.session.mu.Unlock()
+	if ok {
+		<-memoized.done
+		return memoized.result, memoized.err
+	}
+	memoized.result, memoized.err = c.resolveUncached(i, source, binding)
+	close(memoized.done)
+	return memoized.result, memoized.err
+}
+
+func (c *LookupChain) resolveUncached(i int, source Source, binding string) (Result, error) {
+	entry := c.config[i]
+	start := time.Now()
+	result, err := lookupResult(source, binding)
+	if err == nil && len(entry.Transform) > 0 {
+		result.Value, err = applyTransforms(result.Value, entry.Transform)
+		if err != nil {
+			err = fmt.Errorf("transforming value: %w", err)
+		}
+	}
+	var outcome string
+	switch {
+	case err == nil:
+		outcome = "found"
+	case IsNotFoundErr(err):
+		outcome = "not found"
+	default:
+		outcome = "failed"
+	}
+	logging.Debugf("lookup_chain[%d] (%s) for binding %q: %s after %s", i, entry.Source, binding, outcome, time.Since(start))
+	return result, err
+}
+
+// Prefetch looks up the bindings concurrently and memoizes the results,
+// so that the following lookups don't wait for the sources of each binding one after another.
+// For each binding, entries are queried in order until one has a value, like in LookupResult.
+// Errors are reported by the following lookups.
+func (c *LookupChain) Prefetch(bindings ...string) {
+	var wg sync.WaitGroup
+	for _, binding := range bindings {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i, entry := range c.config {
+				if entry.Source == SourceTemplate {
+					// templates depend on other bindings and are rendered by the lookup itself
+					continue
+				}
+				if skip(entry) {
+					continue
+				}
+				source, err := c.source(i)
+				if err != nil {
+					return
+				}
+				if _, err := c.resolve(i, source, binding); err == nil {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
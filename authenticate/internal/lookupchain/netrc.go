@@ -0,0 +1,124 @@
+package lookupchain
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+
+	"github.com/tweag/credential-helper/api"
+	"github.com/tweag/credential-helper/authenticate/internal/netrc"
+)
+
+const SourceNetrc = "netrc"
+
+// Fields of a netrc entry
+const (
+	NetrcFieldLogin    = "login"
+	NetrcFieldPassword = "password"
+	NetrcFieldAccount  = "account"
+)
+
+type Netrc struct {
+	// Source is the name of the source used to look up the secret.
+	// It must be "netrc".
+	Source string `json:"source"`
+	// Path is the path of the netrc file. It is subject to prefix expansion.
+	// Defaults to $NETRC or ~/.netrc (~/_netrc on Windows).
+	Path string `json:"path,omitempty"`
+	// Machine is the machine entry to use.
+	// Defaults to the host of the requested uri.
+	Machine string `json:"machine,omitempty"`
+	// Field is the field of the entry to use: "login", "password" (default) or "account".
+	Field string `json:"field,omitempty"`
+	// Binding binds the value of the field to a well-known name in the helper.
+	// If not specified, the value is bound to the default secret of the helper.
+	Binding string `json:"binding,omitempty"`
+}
+
+func (n *Netrc) Lookup(binding string) (string, error) {
+	if n.Binding != binding {
+		return "", &NotFoundErr{}
+	}
+	machine, err := n.machine()
+	if err != nil {
+		return "", err
+	}
+	file, err := netrc.ReadFile(n.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", &NotFoundErr{reason: err.Error()}
+	}
+	if err != nil {
+		return "", fmt.Errorf("reading netrc file: %w", err)
+	}
+	entry, err := file.Find(machine)
+	if errors.Is(err, netrc.ErrNoEntry) {
+		return "", &NotFoundErr{reason: err.Error()}
+	}
+	if err != nil {
+		return "", err
+	}
+	var value string
+	switch n.Field {
+	case NetrcFieldLogin:
+		value = entry.Login
+	case NetrcFieldPassword:
+		value = entry.Password
+	case NetrcFieldAccount:
+		value = entry.Account
+	default:
+		return "", fmt.Errorf(`unknown netrc field %q. Possible values are "login", "password" and "account"`, n.Field)
+	}
+	if len(value) == 0 {
+		return "", &NotFoundErr{reason: fmt.Sprintf("netrc entry for %s has no %s", machine, n.Field)}
+	}
+	return value, nil
+}
+
+// machine returns the configured machine or the host of the requested uri.
+func (n *Netrc) machine() (string, error) {
+	if len(n.Machine) > 0 {
+		return n.Machine, nil
+	}
+	uri, err := url.Parse(os.Getenv(api.RequestURIEnv))
+	if err != nil {
+		return "", fmt.Errorf("determining netrc machine from the requested uri: %w", err)
+	}
+	if len(uri.Hostname()) == 0 {
+		return "", errors.New("netrc source needs a machine, but no uri was requested")
+	}
+	return uri.Hostname(), nil
+}
+
+func (n *Netrc) Canonicalize() {
+	n.Source = "netrc"
+	if n.Binding == "" {
+		n.Binding = "default"
+	}
+	if n.Field == "" {
+		n.Field = NetrcFieldPassword
+	}
+}
+
+func (n *Netrc) SetupInstructions(binding string) (string, bool) {
+	if n.Binding != binding {
+		return "", false
+	}
+	path := n.Path
+	if len(path) == 0 {
+		path = netrc.DefaultPath()
+	}
+	machine, err := n.machine()
+	if err != nil {
+		machine = "<host>"
+	}
+	status := "SET"
+	if _, err := n.Lookup(binding); IsNotFoundErr(err) {
+		status = "NOT SET"
+	} else if err != nil {
+		status = fmt.Sprintf("ERROR: %v", err)
+	}
+	return fmt.Sprintf(` - Add the %s of the machine %s to the netrc file %s (status: %s):
+    machine %s login <login> password <password>`, n.Field, machine, path, status, machine), true
+}
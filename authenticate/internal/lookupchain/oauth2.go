@@ -0,0 +1,282 @@
+package lookupchain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tweag/credential-helper/api"
+	"github.com/tweag/credential-helper/secretstore"
+	"golang.org/x/oauth2"
+)
+
+const SourceOAuth2 = "oauth2"
+
+// OAuth2 exchanges a refresh token for access tokens.
+// The refresh token is obtained interactively using the OAuth 2.0 device authorization grant (RFC 8628)
+// by the login command and kept in the secret store.
+type OAuth2 struct {
+	// Source is the name of the source used to look up the secret.
+	// It must be "oauth2".
+	Source string `json:"source"`
+	// Issuer is the url of the OpenID Connect issuer.
+	// The endpoints are discovered from its /.well-known/openid-configuration document.
+	Issuer string `json:"issuer,omitempty"`
+	// DeviceAuthURL is the device authorization endpoint. It overrides the discovered endpoint.
+	DeviceAuthURL string `json:"device_auth_url,omitempty"`
+	// TokenURL is the token endpoint. It overrides the discovered endpoint.
+	TokenURL string `json:"token_url,omitempty"`
+	// ClientID is the id of the OAuth 2.0 client.
+	ClientID string `json:"client_id"`
+	// ClientSecretEnv is the optional environment variable holding the client secret.
+	// Public clients (the common case for the device flow) don't have a secret.
+	ClientSecretEnv string `json:"client_secret_env,omitempty"`
+	// Scopes are the requested scopes. Defaults to "openid" and "offline_access".
+	Scopes []string `json:"scopes,omitempty"`
+	// Service is the name of the refresh token in the secret store.
+	// Defaults to a name derived from the issuer and the client id.
+	Service string `json:"service,omitempty"`
+	// Store is the secret store used for the refresh token: "auto" (default), "system" or "file".
+	Store string `json:"store,omitempty"`
+	// Binding binds the access token to a well-known name in the helper.
+	// If not specified, the value is bound to the default secret of the helper.
+	Binding string `json:"binding,omitempty"`
+}
+
+// oauth2Timeout limits the time of a single refresh of an access token.
+const oauth2Timeout = 30 * time.Second
+
+func (o *OAuth2) Lookup(binding string) (string, error) {
+	result, err := o.LookupResult(binding)
+	return result.Value, err
+}
+
+// LookupResult returns a fresh access token together with its expiry.
+func (o *OAuth2) LookupResult(binding string) (Result, error) {
+	if o.Binding != binding {
+		return Result{}, &NotFoundErr{}
+	}
+	store, err := secretstore.Open(o.Store)
+	if err != nil {
+		return Result{}, err
+	}
+	refreshToken, err := store.Get(o.Service)
+	if errors.Is(err, secretstore.ErrNotFound) {
+		return Result{}, &NotFoundErr{reason: fmt.Sprintf("no refresh token for %s in %s - run the login command", o.Service, store.Name())}
+	}
+	if err != nil {
+		return Result{}, fmt.Errorf("reading refresh token: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), oauth2Timeout)
+	defer cancel()
+	config, err := o.config(ctx)
+	if err != nil {
+		return Result{}, err
+	}
+	token, err := config.TokenSource(ctx, &oauth2.Token{RefreshToken: refreshToken}).Token()
+	if err != nil {
+		return Result{}, fmt.Errorf("refreshing access token (try running the login command again): %w", err)
+	}
+	if len(token.RefreshToken) > 0 && token.RefreshToken != refreshToken {
+		// the issuer rotated the refresh token
+		if err := store.Set(o.Service, token.RefreshToken); err != nil {
+			return Result{}, fmt.Errorf("storing rotated refresh token: %w", err)
+		}
+	}
+	return Result{
+		Value:      token.AccessToken,
+		Expires:    token.Expiry,
+		Provenance: fmt.Sprintf("oauth2 access token of client %s from %s", o.ClientID, config.Endpoint.TokenURL),
+	}, nil
+}
+
+// Login runs the device authorization grant and stores the refresh token.
+// The user is asked to visit a url and enter a code in the browser.
+func (o *OAuth2) Login(ctx context.Context, out io.Writer) error {
+	store, err := secretstore.Open(o.Store)
+	if err != nil {
+		return err
+	}
+	config, err := o.config(ctx)
+	if err != nil {
+		return err
+	}
+	if len(config.Endpoint.DeviceAuthURL) == 0 {
+		return errors.New("no device authorization endpoint: set device_auth_url or use an issuer that supports the device flow")
+	}
+	deviceAuth, err := config.DeviceAuth(ctx)
+	if err != nil {
+		return fmt.Errorf("starting device authorization: %w", err)
+	}
+	if len(deviceAuth.VerificationURIComplete) > 0 {
+		fmt.Fprintf(out, "To log in, open the following url in a browser and confirm the code %s:\n  %s\n", deviceAuth.UserCode, deviceAuth.VerificationURIComplete)
+	} else {
+		fmt.Fprintf(out, "To log in, open the following url in a browser and enter the code %s:\n  %s\n", deviceAuth.UserCode, deviceAuth.VerificationURI)
+	}
+	token, err := config.DeviceAccessToken(ctx, deviceAuth)
+	if err != nil {
+		return fmt.Errorf("waiting for device authorization: %w", err)
+	}
+	if len(token.RefreshToken) == 0 {
+		return errors.New(`the issuer did not return a refresh token - does the client allow the "offline_access" scope?`)
+	}
+	if err := store.Set(o.Service, token.RefreshToken); err != nil {
+		return fmt.Errorf("storing refresh token: %w", err)
+	}
+	fmt.Fprintf(out, "Stored refresh token %s in %s\n", o.Service, store.Name())
+	return nil
+}
+
+// Logout deletes the refresh token from the secret store.
+func (o *OAuth2) Logout(out io.Writer) error {
+	store, err := secretstore.Open(o.Store)
+	if err != nil {
+		return err
+	}
+	if err := store.Delete(o.Service); err != nil && !errors.Is(err, secretstore.ErrNotFound) {
+		return fmt.Errorf("deleting refresh token: %w", err)
+	}
+	fmt.Fprintf(out, "Deleted refresh token %s from %s\n", o.Service, store.Name())
+	return nil
+}
+
+func (o *OAuth2) Canonicalize() {
+	o.Source = "oauth2"
+	if o.Binding == "" {
+		o.Binding = "default"
+	}
+	if len(o.Scopes) == 0 {
+		o.Scopes = []string{"openid", "offline_access"}
+	}
+	if o.Service == "" {
+		issuer := o.Issuer
+		if issuer == "" {
+			issuer = o.TokenURL
+		}
+		o.Service = fmt.Sprintf("tweag-credential-helper:oauth2:%s:%s", strings.TrimRight(issuer, "/"), o.ClientID)
+	}
+}
+
+func (o *OAuth2) SetupInstructions(binding string) (string, bool) {
+	if o.Binding != binding {
+		return "", false
+	}
+	var status string
+	store, err := secretstore.Open(o.Store)
+	if err == nil {
+		_, err = store.Get(o.Service)
+	}
+	if errors.Is(err, secretstore.ErrNotFound) {
+		status = "NOT LOGGED IN"
+	} else if err != nil {
+		status = "ERROR ACCESSING SECRET STORE"
+	} else {
+		status = "LOGGED IN"
+	}
+	uri := os.Getenv(api.RequestURIEnv)
+	if uri == "" {
+		uri = "[uri]"
+	}
+	return fmt.Sprintf(` - Log in using the device flow of %s (status: %s):
+    $ %s login %s`, o.issuerOrTokenURL(), status, os.Args[0], uri), true
+}
+
+func (o *OAuth2) issuerOrTokenURL() string {
+	if len(o.Issuer) > 0 {
+		return o.Issuer
+	}
+	return o.TokenURL
+}
+
+// config returns the OAuth 2.0 client configuration.
+// Endpoints that are not configured are discovered from the issuer.
+func (o *OAuth2) config(ctx context.Context) (*oauth2.Config, error) {
+	if len(o.ClientID) == 0 {
+		return nil, errors.New("oauth2 source needs a client_id")
+	}
+	endpoint := oauth2.Endpoint{
+		DeviceAuthURL: o.DeviceAuthURL,
+		TokenURL:      o.TokenURL,
+	}
+	if len(o.Issuer) > 0 && (len(endpoint.DeviceAuthURL) == 0 || len(endpoint.TokenURL) == 0) {
+		discovered, err := discoverEndpoint(ctx, o.Issuer)
+		if err != nil {
+			return nil, err
+		}
+		if len(endpoint.DeviceAuthURL) == 0 {
+			endpoint.DeviceAuthURL = discovered.DeviceAuthURL
+		}
+		if len(endpoint.TokenURL) == 0 {
+			endpoint.TokenURL = discovered.TokenURL
+		}
+	}
+	if len(endpoint.TokenURL) == 0 {
+		return nil, errors.New("oauth2 source needs an issuer or a token_url")
+	}
+	config := &oauth2.Config{
+		ClientID: o.ClientID,
+		Endpoint: endpoint,
+		Scopes:   o.Scopes,
+	}
+	if len(o.ClientSecretEnv) > 0 {
+		config.ClientSecret = os.Getenv(o.ClientSecretEnv)
+	}
+	return config, nil
+}
+
+// discoverEndpoint reads the endpoints from the OpenID Connect discovery document of the issuer.
+func discoverEndpoint(ctx context.Context, issuer string) (oauth2.Endpoint, error) {
+	discoveryURL := strings.TrimRight(issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL, nil)
+	if err != nil {
+		return oauth2.Endpoint{}, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return oauth2.Endpoint{}, fmt.Errorf("discovering endpoints of %s: %w", issuer, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return oauth2.Endpoint{}, fmt.Errorf("discovering endpoints of %s: unexpected status %d", issuer, resp.StatusCode)
+	}
+	var document struct {
+		DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+		TokenEndpoint               string `json:"token_endpoint"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&document); err != nil {
+		return oauth2.Endpoint{}, fmt.Errorf("decoding discovery document of %s: %w", issuer, err)
+	}
+	return oauth2.Endpoint{
+		DeviceAuthURL: document.DeviceAuthorizationEndpoint,
+		TokenURL:      document.TokenEndpoint,
+	}, nil
+}
+
+// Login runs the interactive login of all oauth2 sources in the chain.
+func (c *LookupChain) Login(ctx context.Context, out io.Writer) error {
+	var found bool
+	for i, entry := range c.config {
+		if entry.Source != SourceOAuth2 || skip(entry) {
+			continue
+		}
+		source, err := c.source(i)
+		if err != nil {
+			return err
+		}
+		found = true
+		if err := source.(*OAuth2).Login(ctx, out); err != nil {
+			return fmt.Errorf("lookup_chain[%d]: %w", i, err)
+		}
+	}
+	if !found {
+		return errors.New("the lookup chain has no oauth2 source to log in to")
+	}
+	return nil
+}
@@ -0,0 +1,301 @@
+package lookupchain
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const SourceOIDC = "oidc"
+
+// OIDC providers
+const (
+	OIDCProviderAuto   = "auto"
+	OIDCProviderGitHub = "github"
+	OIDCProviderGitLab = "gitlab"
+)
+
+const (
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	tokenTypeJWT           = "urn:ietf:params:oauth:token-type:jwt"
+	tokenTypeAccessToken   = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+// OIDC obtains an OpenID Connect ID token of the current CI job (workload identity).
+// Optionally, the ID token is exchanged for another token at a security token service (RFC 8693).
+type OIDC struct {
+	// Source is the name of the source used to look up the secret.
+	// It must be "oidc".
+	Source string `json:"source"`
+	// Provider is "auto" (default), "github" or "gitlab".
+	// "auto" detects the provider from the environment.
+	Provider string `json:"provider,omitempty"`
+	// Audience is the audience of the ID token requested on GitHub Actions.
+	// On GitLab, the audience is configured in the id_tokens section of the job.
+	Audience string `json:"audience,omitempty"`
+	// TokenEnv is the environment variable holding the ID token on GitLab.
+	// Defaults to CI_JOB_JWT_V2.
+	TokenEnv string `json:"token_env,omitempty"`
+	// Exchange optionally exchanges the ID token for another token.
+	Exchange *OIDCExchange `json:"exchange,omitempty"`
+	// Binding binds the token to a well-known name in the helper.
+	// If not specified, the value is bound to the default secret of the helper.
+	Binding string `json:"binding,omitempty"`
+}
+
+// OIDCExchange describes an OAuth 2.0 token exchange (RFC 8693).
+type OIDCExchange struct {
+	// TokenURL is the token endpoint of the security token service.
+	TokenURL string `json:"token_url"`
+	// Audience is the optional audience of the requested token.
+	Audience string `json:"audience,omitempty"`
+	// Resource is the optional resource of the requested token.
+	Resource string `json:"resource,omitempty"`
+	// Scopes are the optional scopes of the requested token.
+	Scopes []string `json:"scopes,omitempty"`
+	// SubjectTokenType is the type of the ID token. Defaults to "urn:ietf:params:oauth:token-type:jwt".
+	SubjectTokenType string `json:"subject_token_type,omitempty"`
+	// RequestedTokenType is the type of the requested token. Defaults to "urn:ietf:params:oauth:token-type:access_token".
+	RequestedTokenType string `json:"requested_token_type,omitempty"`
+	// ClientID is the optional client id used to authenticate to the token endpoint.
+	ClientID string `json:"client_id,omitempty"`
+	// ClientSecretEnv is the optional environment variable holding the client secret.
+	ClientSecretEnv string `json:"client_secret_env,omitempty"`
+}
+
+func (o *OIDC) Lookup(binding string) (string, error) {
+	result, err := o.LookupResult(binding)
+	return result.Value, err
+}
+
+// LookupResult returns the token together with its expiry.
+func (o *OIDC) LookupResult(binding string) (Result, error) {
+	if o.Binding != binding {
+		return Result{}, &NotFoundErr{}
+	}
+	provider, err := o.provider()
+	if err != nil {
+		return Result{}, err
+	}
+	var idToken string
+	switch provider {
+	case OIDCProviderGitHub:
+		idToken, err = o.githubIDToken()
+	case OIDCProviderGitLab:
+		idToken, err = o.gitlabIDToken()
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	result := Result{
+		Value:      idToken,
+		Expires:    jwtExpiry(idToken),
+		Provenance: fmt.Sprintf("oidc id token of %s", provider),
+	}
+	if o.Exchange == nil {
+		return result, nil
+	}
+	return o.Exchange.exchange(result)
+}
+
+func (o *OIDC) Canonicalize() {
+	o.Source = "oidc"
+	if o.Binding == "" {
+		o.Binding = "default"
+	}
+	if o.Provider == "" {
+		o.Provider = OIDCProviderAuto
+	}
+	if o.TokenEnv == "" {
+		o.TokenEnv = "CI_JOB_JWT_V2"
+	}
+	if o.Exchange != nil {
+		if o.Exchange.SubjectTokenType == "" {
+			o.Exchange.SubjectTokenType = tokenTypeJWT
+		}
+		if o.Exchange.RequestedTokenType == "" {
+			o.Exchange.RequestedTokenType = tokenTypeAccessToken
+		}
+	}
+}
+
+func (o *OIDC) SetupInstructions(binding string) (string, bool) {
+	if o.Binding != binding {
+		return "", false
+	}
+	var status string
+	if provider, err := o.provider(); err != nil {
+		status = "NOT AVAILABLE"
+	} else {
+		status = "AVAILABLE ON " + strings.ToUpper(provider)
+	}
+	return fmt.Sprintf(` - Run in a CI job that can request OIDC ID tokens (status: %s):
+    GitHub Actions: grant the job "permissions: id-token: write"
+    GitLab CI: declare an ID token in the "id_tokens" section of the job and expose it as $%s`, status, o.TokenEnv), true
+}
+
+// provider returns the CI provider to obtain the ID token from.
+func (o *OIDC) provider() (string, error) {
+	switch o.Provider {
+	case OIDCProviderGitHub, OIDCProviderGitLab:
+		return o.Provider, nil
+	case OIDCProviderAuto:
+		if len(os.Getenv("ACTIONS_ID_TOKEN_REQUEST_URL")) > 0 {
+			return OIDCProviderGitHub, nil
+		}
+		if os.Getenv("GITLAB_CI") == "true" {
+			return OIDCProviderGitLab, nil
+		}
+		return "", &NotFoundErr{reason: "no CI provider with OIDC support detected"}
+	}
+	return "", fmt.Errorf(`unknown oidc provider %q. Possible values are "auto", "github" and "gitlab"`, o.Provider)
+}
+
+// githubIDToken requests an ID token from the GitHub Actions runtime.
+func (o *OIDC) githubIDToken() (string, error) {
+	requestURL := os.Getenv("ACTIONS_ID_TOKEN_REQUEST_URL")
+	requestToken := os.Getenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN")
+	if len(requestURL) == 0 || len(requestToken) == 0 {
+		return "", &NotFoundErr{reason: `$ACTIONS_ID_TOKEN_REQUEST_URL or $ACTIONS_ID_TOKEN_REQUEST_TOKEN is not set - does the job have "permissions: id-token: write"?`}
+	}
+	parsed, err := url.Parse(requestURL)
+	if err != nil {
+		return "", fmt.Errorf("parsing $ACTIONS_ID_TOKEN_REQUEST_URL: %w", err)
+	}
+	if len(o.Audience) > 0 {
+		query := parsed.Query()
+		query.Set("audience", o.Audience)
+		parsed.RawQuery = query.Encode()
+	}
+	req, err := http.NewRequest(http.MethodGet, parsed.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+requestToken)
+	req.Header.Set("Accept", "application/json")
+	resp, err := oidcClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("requesting github actions id token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("requesting github actions id token: unexpected status %d", resp.StatusCode)
+	}
+	var response struct {
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return "", fmt.Errorf("decoding github actions id token response: %w", err)
+	}
+	if len(response.Value) == 0 {
+		return "", errors.New("requesting github actions id token: empty token in response")
+	}
+	return response.Value, nil
+}
+
+// gitlabIDToken reads the ID token that GitLab CI exposes to the job.
+func (o *OIDC) gitlabIDToken() (string, error) {
+	token, ok := os.LookupEnv(o.TokenEnv)
+	if !ok || len(token) == 0 {
+		return "", &NotFoundErr{reason: fmt.Sprintf("$%s is not set - is it declared in the id_tokens section of the job?", o.TokenEnv)}
+	}
+	return token, nil
+}
+
+// exchange exchanges the ID token at the token endpoint.
+func (e *OIDCExchange) exchange(idToken Result) (Result, error) {
+	if len(e.TokenURL) == 0 {
+		return Result{}, errors.New("oidc token exchange needs a token_url")
+	}
+	form := url.Values{
+		"grant_type":           {tokenExchangeGrantType},
+		"subject_token":        {idToken.Value},
+		"subject_token_type":   {e.SubjectTokenType},
+		"requested_token_type": {e.RequestedTokenType},
+	}
+	if len(e.Audience) > 0 {
+		form.Set("audience", e.Audience)
+	}
+	if len(e.Resource) > 0 {
+		form.Set("resource", e.Resource)
+	}
+	if len(e.Scopes) > 0 {
+		form.Set("scope", strings.Join(e.Scopes, " "))
+	}
+	var clientSecret string
+	if len(e.ClientSecretEnv) > 0 {
+		clientSecret = os.Getenv(e.ClientSecretEnv)
+	}
+	if len(e.ClientID) > 0 && len(clientSecret) == 0 {
+		form.Set("client_id", e.ClientID)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Result{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if len(e.ClientID) > 0 && len(clientSecret) > 0 {
+		req.SetBasicAuth(url.QueryEscape(e.ClientID), url.QueryEscape(clientSecret))
+	}
+	requestTime := time.Now()
+	resp, err := oidcClient.Do(req)
+	if err != nil {
+		return Result{}, fmt.Errorf("exchanging oidc token: %w", err)
+	}
+	defer resp.Body.Close()
+	var response struct {
+		AccessToken      string `json:"access_token"`
+		ExpiresIn        int    `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil && resp.StatusCode == http.StatusOK {
+		return Result{}, fmt.Errorf("decoding token exchange response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Result{}, fmt.Errorf("exchanging oidc token at %s: status %d: %s %s", e.TokenURL, resp.StatusCode, response.Error, response.ErrorDescription)
+	}
+	if len(response.AccessToken) == 0 {
+		return Result{}, errors.New("exchanging oidc token: no access_token in response")
+	}
+	result := Result{
+		Value:      response.AccessToken,
+		Provenance: fmt.Sprintf("%s exchanged at %s", idToken.Provenance, e.TokenURL),
+	}
+	if response.ExpiresIn > 0 {
+		result.Expires = requestTime.Add(time.Duration(response.ExpiresIn) * time.Second)
+	} else {
+		result.Expires = jwtExpiry(response.AccessToken)
+	}
+	return result, nil
+}
+
+var oidcClient = &http.Client{Timeout: 30 * time.Second}
+
+// jwtExpiry returns the expiry (exp claim) of a JWT without verifying it.
+// It returns the zero time if the token is not a JWT or has no expiry.
+func jwtExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Exp, 0)
+}
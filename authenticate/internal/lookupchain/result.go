@@ -0,0 +1,44 @@
+package lookupchain
+
+import "time"
+
+// Result is a value found by a source, together with optional metadata.
+type Result struct {
+	// Value is the secret.
+	Value string
+	// Expires is the time after which the value must not be used anymore.
+	// It is zero if the source doesn't know when the value expires.
+	Expires time.Time
+	// Provenance describes where the value came from.
+	// If the source leaves it empty, the lookup chain names the source and its position in the chain.
+	Provenance string
+}
+
+// ResultSource is implemented by sources that know more about a value than the value itself,
+// like when it expires.
+type ResultSource interface {
+	LookupResult(binding string) (Result, error)
+}
+
+func lookupResult(source Source, binding string) (Result, error) {
+	if resultSource, ok := source.(ResultSource); ok {
+		return resultSource.LookupResult(binding)
+	}
+	value, err := source.Lookup(binding)
+	return Result{Value: value}, err
+}
+
+// EarliestExpiry returns the earliest non-zero expiry of the results.
+// It returns the zero time if no result expires.
+func EarliestExpiry(results ...Result) time.Time {
+	var earliest time.Time
+	for _, result := range results {
+		if result.Expires.IsZero() {
+			continue
+		}
+		if earliest.IsZero() || result.Expires.Before(earliest) {
+			earliest = result.Expires
+		}
+	}
+	return earliest
+}
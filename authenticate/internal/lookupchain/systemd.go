@@ -0,0 +1,87 @@
+package lookupchain
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+const SourceSystemdCredential = "systemd_credential"
+
+// credentialsDirectoryEnv is set by systemd for services that load credentials.
+const credentialsDirectoryEnv = "CREDENTIALS_DIRECTORY"
+
+// SystemdCredential reads a credential passed to a systemd service
+// using LoadCredential= or LoadCredentialEncrypted= (see systemd.exec(5)).
+type SystemdCredential struct {
+	// Source is the name of the source used to look up the secret.
+	// It must be "systemd_credential".
+	Source string `json:"source"`
+	// Name is the name of the credential, which is the name of the file in $CREDENTIALS_DIRECTORY.
+	Name string `json:"name"`
+	// Binding binds the value of the credential to a well-known name in the helper.
+	// If not specified, the value is bound to the default secret of the helper.
+	Binding string `json:"binding,omitempty"`
+}
+
+func (s *SystemdCredential) Lookup(binding string) (string, error) {
+	if s.Binding != binding {
+		return "", &NotFoundErr{}
+	}
+	path, err := s.path()
+	if err != nil {
+		return "", err
+	}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", &NotFoundErr{reason: fmt.Sprintf("systemd credential %s not found in $%s", s.Name, credentialsDirectoryEnv)}
+	}
+	if err != nil {
+		return "", fmt.Errorf("reading systemd credential %s: %w", s.Name, err)
+	}
+	return strings.TrimRightFunc(string(content), unicode.IsSpace), nil
+}
+
+func (s *SystemdCredential) Canonicalize() {
+	s.Source = "systemd_credential"
+	if s.Binding == "" {
+		s.Binding = "default"
+	}
+}
+
+func (s *SystemdCredential) SetupInstructions(binding string) (string, bool) {
+	if s.Binding != binding {
+		return "", false
+	}
+	var status string
+	if path, err := s.path(); err != nil {
+		status = "NOT RUNNING AS SYSTEMD SERVICE WITH CREDENTIALS"
+	} else if _, err := os.Stat(path); err != nil {
+		status = "NOT FOUND"
+	} else {
+		status = "FOUND"
+	}
+	return fmt.Sprintf(` - Pass the secret as the systemd credential %[1]s to the service running Bazel (status: %[2]s).
+   Add one of the following lines to the [Service] section of the unit file:
+    LoadCredential=%[1]s:/path/to/secret
+    LoadCredentialEncrypted=%[1]s:/path/to/secret.cred
+   Encrypted credentials can be created using:
+    $ systemd-creds encrypt --name=%[1]s secret.txt secret.cred`, s.Name, status), true
+}
+
+// path returns the path of the credential.
+// It returns a NotFoundErr if the process doesn't run with systemd credentials.
+func (s *SystemdCredential) path() (string, error) {
+	if len(s.Name) == 0 || strings.ContainsAny(s.Name, `/\`) || s.Name == "." || s.Name == ".." {
+		return "", fmt.Errorf("invalid systemd credential name %q", s.Name)
+	}
+	dir := os.Getenv(credentialsDirectoryEnv)
+	if len(dir) == 0 {
+		return "", &NotFoundErr{reason: fmt.Sprintf("$%s is not set", credentialsDirectoryEnv)}
+	}
+	return filepath.Join(dir, s.Name), nil
+}
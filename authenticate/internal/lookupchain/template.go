@@ -0,0 +1,175 @@
+package lookupchain
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const SourceTemplate = "template"
+
+// maxTemplateDepth limits how deeply templates may reference other templates.
+const maxTemplateDepth = 8
+
+// Render expands a template by replacing every {{binding}} with the value of the binding.
+// A binding may be followed by a pipeline of transforms, like {{ token | prefix:'Bearer ' }}.
+// Text outside of {{ and }} is copied verbatim.
+func (c *LookupChain) Render(template string) (string, error) {
+	result, err := c.render(template)
+	return result.Value, err
+}
+
+// RenderResult expands a template like Render and returns the value together with its expiry.
+// The result expires when the first referenced binding expires.
+func (c *LookupChain) RenderResult(template string) (Result, error) {
+	return c.render(template)
+}
+
+// References returns the bindings referenced by a template in order of appearance.
+func References(template string) ([]string, error) {
+	var bindings []string
+	rest := template
+	for {
+		start := strings.Index(rest, "{{")
+		if start < 0 {
+			return bindings, nil
+		}
+		end := closingBraces(rest[start+2:])
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated binding reference in template %q", template)
+		}
+		binding := strings.TrimSpace(splitPipeline(rest[start+2 : start+2+end])[0])
+		if len(binding) == 0 {
+			return nil, fmt.Errorf("empty binding reference in template %q", template)
+		}
+		if !slices.Contains(bindings, binding) {
+			bindings = append(bindings, binding)
+		}
+		rest = rest[start+2+end+2:]
+	}
+}
+
+// render expands a template. The returned result expires when the first referenced binding expires.
+func (c *LookupChain) render(template string) (Result, error) {
+	var out strings.Builder
+	var results []Result
+	rest := template
+	for {
+		start := strings.Index(rest, "{{")
+		if start < 0 {
+			out.WriteString(rest)
+			return Result{Value: out.String(), Expires: EarliestExpiry(results...)}, nil
+		}
+		end := closingBraces(rest[start+2:])
+		if end < 0 {
+			return Result{}, fmt.Errorf("unterminated binding reference in template %q", template)
+		}
+		out.WriteString(rest[:start])
+		pipeline := splitPipeline(rest[start+2 : start+2+end])
+		binding := strings.TrimSpace(pipeline[0])
+		if len(binding) == 0 {
+			return Result{}, fmt.Errorf("empty binding reference in template %q", template)
+		}
+		result, err := c.LookupResult(binding)
+		if err != nil {
+			return Result{}, err
+		}
+		value, err := applyTransforms(result.Value, pipeline[1:])
+		if err != nil {
+			return Result{}, fmt.Errorf("binding %q: %w", binding, err)
+		}
+		results = append(results, result)
+		out.WriteString(value)
+		rest = rest[start+2+end+2:]
+	}
+}
+
+// closingBraces returns the index of the first "}}" outside of quotes, or -1.
+func closingBraces(s string) int {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote != 0:
+			if s[i] == quote {
+				quote = 0
+			}
+		case s[i] == '\'' || s[i] == '"':
+			quote = s[i]
+		case strings.HasPrefix(s[i:], "}}"):
+			return i
+		}
+	}
+	return -1
+}
+
+// splitPipeline splits "binding | transform | transform:'arg'" at every | outside of quotes.
+func splitPipeline(s string) []string {
+	var parts []string
+	var quote byte
+	last := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote != 0:
+			if s[i] == quote {
+				quote = 0
+			}
+		case s[i] == '\'' || s[i] == '"':
+			quote = s[i]
+		case s[i] == '|':
+			parts = append(parts, s[last:i])
+			last = i + 1
+		}
+	}
+	return append(parts, s[last:])
+}
+
+// Template composes other bindings of the same lookup chain into a value.
+type Template struct {
+	// Source is the name of the source used to look up the secret.
+	// It must be "template".
+	Source string `json:"source"`
+	// Template references other bindings as {{binding}}, optionally followed by transforms,
+	// like "{{ username }}:{{ password }}" or "{{ token | prefix:'Bearer ' }}".
+	Template string `json:"template"`
+	// Binding binds the rendered template to a well-known name in the helper.
+	// If not specified, the value is bound to the default secret of the helper.
+	Binding string `json:"binding,omitempty"`
+
+	chain *LookupChain
+}
+
+func (t *Template) Lookup(binding string) (string, error) {
+	result, err := t.LookupResult(binding)
+	return result.Value, err
+}
+
+// LookupResult renders the template. The result expires when the first referenced binding expires.
+func (t *Template) LookupResult(binding string) (Result, error) {
+	if t.Binding != binding {
+		return Result{}, &NotFoundErr{}
+	}
+	if t.chain == nil {
+		return Result{}, errors.New("template source used outside of a lookup chain")
+	}
+	if t.chain.templateDepth >= maxTemplateDepth {
+		return Result{}, fmt.Errorf("template for binding %q references itself or is nested too deeply", binding)
+	}
+	t.chain.templateDepth++
+	defer func() { t.chain.templateDepth-- }()
+	return t.chain.render(t.Template)
+}
+
+func (t *Template) Canonicalize() {
+	t.Source = "template"
+	if t.Binding == "" {
+		t.Binding = "default"
+	}
+}
+
+func (t *Template) SetupInstructions(binding string) (string, bool) {
+	if t.Binding != binding {
+		return "", false
+	}
+	return fmt.Sprintf(" - The value is composed from other secrets using the template %q. Set up the secrets it references.", t.Template), true
+}
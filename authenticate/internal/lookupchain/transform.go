@@ -0,0 +1,68 @@
+package lookupchain
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// transformFunc converts a value. The argument is the text after the colon in "name:argument".
+type transformFunc func(value, argument string) (string, error)
+
+var transforms = map[string]transformFunc{
+	"base64": func(value, _ string) (string, error) {
+		return base64.StdEncoding.EncodeToString([]byte(value)), nil
+	},
+	"base64url": func(value, _ string) (string, error) {
+		return base64.URLEncoding.EncodeToString([]byte(value)), nil
+	},
+	"base64decode": func(value, _ string) (string, error) {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		return string(decoded), err
+	},
+	"trim": func(value, _ string) (string, error) {
+		return strings.TrimSpace(value), nil
+	},
+	"prefix": func(value, argument string) (string, error) {
+		return argument + value, nil
+	},
+	"suffix": func(value, argument string) (string, error) {
+		return value + argument, nil
+	},
+	"json": func(value, argument string) (string, error) {
+		if len(argument) == 0 {
+			return "", errors.New("json needs a field path, like json:data.token")
+		}
+		return jsonField([]byte(value), argument)
+	},
+}
+
+// applyTransforms applies a pipeline of transforms (like ["json:token", "prefix:Bearer "]) to a value.
+func applyTransforms(value string, pipeline []string) (string, error) {
+	for _, step := range pipeline {
+		name, argument, _ := strings.Cut(strings.TrimSpace(step), ":")
+		transform, ok := transforms[name]
+		if !ok {
+			return "", fmt.Errorf("unknown transform %q. Possible values are base64, base64url, base64decode, trim, prefix, suffix and json", name)
+		}
+		var err error
+		value, err = transform(value, unquote(argument))
+		if err != nil {
+			return "", fmt.Errorf("transform %s: %w", name, err)
+		}
+	}
+	return value, nil
+}
+
+// unquote removes a pair of single or double quotes around an argument.
+// Quotes allow arguments with leading or trailing spaces and, in templates, the characters | and }.
+func unquote(argument string) string {
+	if len(argument) >= 2 {
+		first, last := argument[0], argument[len(argument)-1]
+		if (first == '\'' || first == '"') && first == last {
+			return argument[1 : len(argument)-1]
+		}
+	}
+	return argument
+}
@@ -0,0 +1,390 @@
+package lookupchain
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tweag/credential-helper/agent/locate"
+	"github.com/tweag/credential-helper/logging"
+)
+
+const SourceVault = "vault"
+
+// Vault authentication methods
+const (
+	VaultAuthToken   = "token"
+	VaultAuthAppRole = "approle"
+	VaultAuthJWT     = "jwt"
+)
+
+// Vault reads a field of a secret from the KV version 2 secrets engine of HashiCorp Vault.
+type Vault struct {
+	// Source is the name of the source used to look up the secret.
+	// It must be "vault".
+	Source string `json:"source"`
+	// Address is the url of the Vault server. Defaults to $VAULT_ADDR.
+	Address string `json:"address,omitempty"`
+	// Namespace is the Vault Enterprise namespace. Defaults to $VAULT_NAMESPACE.
+	Namespace string `json:"namespace,omitempty"`
+	// CACert is the path of a PEM-encoded CA bundle used to verify the server.
+	// It is subject to prefix expansion. Defaults to $VAULT_CACERT.
+	CACert string `json:"ca_cert,omitempty"`
+	// Mount is the mount path of the KV secrets engine. Defaults to "secret".
+	Mount string `json:"mount,omitempty"`
+	// Path is the path of the secret within the mount.
+	Path string `json:"path"`
+	// Field is the key of the value within the secret.
+	Field string `json:"field"`
+	// Auth configures how the helper authenticates to Vault.
+	Auth VaultAuth `json:"auth"`
+	// Binding binds the value of the field to a well-known name in the helper.
+	// If not specified, the value is bound to the default secret of the helper.
+	Binding string `json:"binding,omitempty"`
+}
+
+type VaultAuth struct {
+	// Method is "token" (default), "approle" or "jwt".
+	Method string `json:"method,omitempty"`
+	// Mount is the mount path of the auth method. Defaults to the name of the method.
+	Mount string `json:"mount,omitempty"`
+	// TokenEnv is the environment variable holding the token for the token method. Defaults to VAULT_TOKEN.
+	TokenEnv string `json:"token_env,omitempty"`
+	// TokenFile is the file holding the token for the token method, if the environment variable is unset.
+	// Defaults to ~/.vault-token.
+	TokenFile string `json:"token_file,omitempty"`
+	// RoleID is the role id for the approle method.
+	RoleID string `json:"role_id,omitempty"`
+	// SecretIDEnv is the environment variable holding the secret id for the approle method.
+	SecretIDEnv string `json:"secret_id_env,omitempty"`
+	// SecretIDFile is the file holding the secret id for the approle method.
+	SecretIDFile string `json:"secret_id_file,omitempty"`
+	// Role is the role for the jwt method.
+	Role string `json:"role,omitempty"`
+	// JWTEnv is the environment variable holding the jwt for the jwt method.
+	JWTEnv string `json:"jwt_env,omitempty"`
+	// JWTFile is the file holding the jwt for the jwt method.
+	JWTFile string `json:"jwt_file,omitempty"`
+}
+
+type vaultSecret struct {
+	data map[string]any
+	// expires is the end of the lease of the secret. It is zero if the secret has no lease.
+	expires time.Time
+}
+
+type vaultSecretResult struct {
+	done   chan struct{}
+	secret vaultSecret
+	err    error
+}
+
+type vaultTokenResult struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+// vaultResults memoizes logins and secrets for the lifetime of the process.
+// Helpers often look up several bindings, which may all come from the same secret.
+// The lock only guards the maps: different secrets are read concurrently.
+var vaultResults = struct {
+	sync.Mutex
+	tokens  map[string]*vaultTokenResult
+	secrets map[string]*vaultSecretResult
+}{tokens: make(map[string]*vaultTokenResult), secrets: make(map[string]*vaultSecretResult)}
+
+func (v *Vault) Lookup(binding string) (string, error) {
+	result, err := v.LookupResult(binding)
+	return result.Value, err
+}
+
+// LookupResult returns the value together with the end of the lease of the secret.
+func (v *Vault) LookupResult(binding string) (Result, error) {
+	if v.Binding != binding {
+		return Result{}, &NotFoundErr{}
+	}
+	secret, err := v.read()
+	if err != nil {
+		return Result{}, err
+	}
+	if !secret.expires.IsZero() && time.Now().After(secret.expires) {
+		return Result{}, fmt.Errorf("lease of vault secret %s/%s expired", v.Mount, v.Path)
+	}
+	value, ok := secret.data[v.Field]
+	if !ok {
+		return Result{}, &NotFoundErr{reason: fmt.Sprintf("vault secret %s/%s has no field %q", v.Mount, v.Path, v.Field)}
+	}
+	stringValue, ok := value.(string)
+	if !ok {
+		return Result{}, fmt.Errorf("field %q of vault secret %s/%s is not a string", v.Field, v.Mount, v.Path)
+	}
+	return Result{
+		Value:      stringValue,
+		Expires:    secret.expires,
+		Provenance: fmt.Sprintf("vault secret %s/%s on %s", v.Mount, v.Path, v.Address),
+	}, nil
+}
+
+func (v *Vault) read() (vaultSecret, error) {
+	if len(v.Address) == 0 {
+		return vaultSecret{}, &NotFoundErr{reason: "no vault address configured and $VAULT_ADDR is not set"}
+	}
+	if len(v.Path) == 0 || len(v.Field) == 0 {
+		return vaultSecret{}, errors.New("vault source needs a path and a field")
+	}
+	// sources with different auth configs may not be allowed to read the same secret
+	auth, err := json.Marshal(v.Auth)
+	if err != nil {
+		return vaultSecret{}, err
+	}
+	key := strings.Join([]string{v.Address, v.Namespace, v.Mount, v.Path, string(auth)}, "\x00")
+
+	vaultResults.Lock()
+	result, ok := vaultResults.secrets[key]
+	if !ok {
+		result = &vaultSecretResult{done: make(chan struct{})}
+		vaultResults.secrets[key] = result
+	}
+	vaultResults.Unlock()
+	if ok {
+		<-result.done
+		return result.secret, result.err
+	}
+	result.secret, result.err = v.fetch()
+	close(result.done)
+	return result.secret, result.err
+}
+
+// fetch logs in and reads the secret from Vault.
+func (v *Vault) fetch() (vaultSecret, error) {
+	client, err := v.client()
+	if err != nil {
+		return vaultSecret{}, err
+	}
+	token, err := v.login(client)
+	if err != nil {
+		return vaultSecret{}, err
+	}
+
+	var response struct {
+		LeaseDuration int `json:"lease_duration"`
+		Data          struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+	}
+	requestTime := time.Now()
+	path := fmt.Sprintf("/v1/%s/data/%s", strings.Trim(v.Mount, "/"), strings.TrimLeft(v.Path, "/"))
+	if err := v.do(client, http.MethodGet, path, token, nil, &response); err != nil {
+		return vaultSecret{}, err
+	}
+	secret := vaultSecret{data: response.Data.Data}
+	if response.LeaseDuration > 0 {
+		secret.expires = requestTime.Add(time.Duration(response.LeaseDuration) * time.Second)
+		logging.Debugf("vault secret %s expires at %s", path, secret.expires)
+	}
+	return secret, nil
+}
+
+// login returns a Vault token.
+// Tokens obtained from an auth method are memoized, so that concurrent reads share a single login.
+func (v *Vault) login(client *http.Client) (string, error) {
+	var body map[string]string
+	switch v.Auth.Method {
+	case VaultAuthToken:
+		if token, ok := os.LookupEnv(v.Auth.TokenEnv); ok && len(token) > 0 {
+			return token, nil
+		}
+		token, err := readSecretFile(v.Auth.TokenFile)
+		if errors.Is(err, os.ErrNotExist) {
+			return "", &NotFoundErr{reason: fmt.Sprintf("no vault token: $%s is not set and %s does not exist", v.Auth.TokenEnv, v.Auth.TokenFile)}
+		}
+		return token, err
+	case VaultAuthAppRole:
+		secretID, err := readSecret(v.Auth.SecretIDEnv, v.Auth.SecretIDFile)
+		if err != nil {
+			return "", fmt.Errorf("reading approle secret id: %w", err)
+		}
+		body = map[string]string{"role_id": v.Auth.RoleID, "secret_id": secretID}
+	case VaultAuthJWT:
+		jwt, err := readSecret(v.Auth.JWTEnv, v.Auth.JWTFile)
+		if err != nil {
+			return "", fmt.Errorf("reading jwt: %w", err)
+		}
+		body = map[string]string{"role": v.Auth.Role, "jwt": jwt}
+	default:
+		return "", fmt.Errorf(`unknown vault auth method %q. Possible values are "token", "approle" and "jwt"`, v.Auth.Method)
+	}
+
+	path := fmt.Sprintf("/v1/auth/%s/login", strings.Trim(v.Auth.Mount, "/"))
+	key := strings.Join([]string{v.Address, v.Namespace, path, body["role_id"], body["role"]}, "\x00")
+	vaultResults.Lock()
+	result, ok := vaultResults.tokens[key]
+	if !ok {
+		result = &vaultTokenResult{done: make(chan struct{})}
+		vaultResults.tokens[key] = result
+	}
+	vaultResults.Unlock()
+	if ok {
+		<-result.done
+		return result.token, result.err
+	}
+	result.token, result.err = v.authenticate(client, path, body)
+	close(result.done)
+	return result.token, result.err
+}
+
+// authenticate logs in to the auth method at path.
+func (v *Vault) authenticate(client *http.Client, path string, body map[string]string) (string, error) {
+	var response struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	if err := v.do(client, http.MethodPost, path, "", body, &response); err != nil {
+		return "", fmt.Errorf("logging in to vault: %w", err)
+	}
+	if len(response.Auth.ClientToken) == 0 {
+		return "", errors.New("logging in to vault: no client token in response")
+	}
+	return response.Auth.ClientToken, nil
+}
+
+func (v *Vault) do(client *http.Client, method, path, token string, body any, out any) error {
+	var reader io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequest(method, strings.TrimRight(v.Address, "/")+path, reader)
+	if err != nil {
+		return err
+	}
+	if len(token) > 0 {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if len(v.Namespace) > 0 {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return &NotFoundErr{reason: fmt.Sprintf("vault returned 404 for %s", path)}
+	}
+	if resp.StatusCode != http.StatusOK {
+		var vaultErr struct {
+			Errors []string `json:"errors"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&vaultErr)
+		return fmt.Errorf("vault returned status %d for %s: %s", resp.StatusCode, path, strings.Join(vaultErr.Errors, "; "))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (v *Vault) client() (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if len(v.CACert) > 0 {
+		pem, err := os.ReadFile(locate.ExpandPath(v.CACert))
+		if err != nil {
+			return nil, fmt.Errorf("reading vault CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in vault CA bundle %s", v.CACert)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return &http.Client{Transport: transport, Timeout: 30 * time.Second}, nil
+}
+
+// readSecret reads a secret from an environment variable or, if it is unset, from a file.
+func readSecret(envName, path string) (string, error) {
+	if len(envName) > 0 {
+		if value, ok := os.LookupEnv(envName); ok && len(value) > 0 {
+			return value, nil
+		}
+	}
+	if len(path) == 0 {
+		return "", &NotFoundErr{reason: fmt.Sprintf("$%s is not set and no file is configured", envName)}
+	}
+	return readSecretFile(path)
+}
+
+func readSecretFile(path string) (string, error) {
+	content, err := os.ReadFile(locate.ExpandPath(path))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+func (v *Vault) Canonicalize() {
+	v.Source = "vault"
+	if v.Binding == "" {
+		v.Binding = "default"
+	}
+	if v.Address == "" {
+		v.Address = os.Getenv("VAULT_ADDR")
+	}
+	if v.Namespace == "" {
+		v.Namespace = os.Getenv("VAULT_NAMESPACE")
+	}
+	if v.CACert == "" {
+		v.CACert = os.Getenv("VAULT_CACERT")
+	}
+	if v.Mount == "" {
+		v.Mount = "secret"
+	}
+	if v.Auth.Method == "" {
+		v.Auth.Method = VaultAuthToken
+	}
+	if v.Auth.Mount == "" {
+		v.Auth.Mount = v.Auth.Method
+	}
+	if v.Auth.TokenEnv == "" {
+		v.Auth.TokenEnv = "VAULT_TOKEN"
+	}
+	if v.Auth.TokenFile == "" {
+		v.Auth.TokenFile = "~/.vault-token"
+	}
+}
+
+func (v *Vault) SetupInstructions(binding string) (string, bool) {
+	if v.Binding != binding {
+		return "", false
+	}
+	status := "SET"
+	if _, err := v.Lookup(binding); IsNotFoundErr(err) {
+		status = "NOT SET"
+	} else if err != nil {
+		status = fmt.Sprintf("ERROR: %v", err)
+	}
+	instruction := fmt.Sprintf(" - Store the secret in the field %q of the Vault secret %s/%s on %s (status: %s)", v.Field, v.Mount, v.Path, v.Address, status)
+	switch v.Auth.Method {
+	case VaultAuthToken:
+		instruction += fmt.Sprintf("\n   Log in to Vault (vault login) or export $%s.", v.Auth.TokenEnv)
+	case VaultAuthAppRole:
+		instruction += fmt.Sprintf("\n   The helper logs in using the AppRole %s.", v.Auth.RoleID)
+	case VaultAuthJWT:
+		instruction += fmt.Sprintf("\n   The helper logs in using a JWT for the role %s.", v.Auth.Role)
+	}
+	return instruction, true
+}
@@ -0,0 +1,64 @@
+package lookupchain
+
+import (
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/tweag/credential-helper/api"
+)
+
+// Condition decides whether a lookup chain entry is used.
+// All predicates that are set must hold. Predicates taking a list hold if any value in the list matches.
+type Condition struct {
+	// EnvSet lists environment variables that must be set to a non-empty value.
+	EnvSet []string `json:"env_set,omitempty"`
+	// EnvEquals maps environment variables to their expected values.
+	EnvEquals map[string]string `json:"env_equals,omitempty"`
+	// OS lists operating systems (values of runtime.GOOS, like "linux", "darwin" or "windows").
+	OS []string `json:"os,omitempty"`
+	// CI requires running in a CI environment (true) or outside of one (false).
+	CI *bool `json:"ci,omitempty"`
+	// CIProvider lists detected CI providers (like "GitHub Actions" or "GitLab CI"), compared case-insensitively.
+	CIProvider []string `json:"ci_provider,omitempty"`
+	// Profile lists names of the active profile.
+	Profile []string `json:"profile,omitempty"`
+	// Not negates a nested condition.
+	Not *Condition `json:"not,omitempty"`
+}
+
+// Holds returns true if all predicates of the condition hold.
+// A nil condition always holds.
+func (c *Condition) Holds() bool {
+	if c == nil {
+		return true
+	}
+	for _, name := range c.EnvSet {
+		if len(os.Getenv(name)) == 0 {
+			return false
+		}
+	}
+	for name, value := range c.EnvEquals {
+		if os.Getenv(name) != value {
+			return false
+		}
+	}
+	if len(c.OS) > 0 && !slices.Contains(c.OS, runtime.GOOS) {
+		return false
+	}
+	ciProvider := os.Getenv(api.CIProviderEnv)
+	if c.CI != nil && *c.CI != (len(ciProvider) > 0) {
+		return false
+	}
+	if len(c.CIProvider) > 0 && !slices.ContainsFunc(c.CIProvider, func(name string) bool { return strings.EqualFold(name, ciProvider) }) {
+		return false
+	}
+	if len(c.Profile) > 0 && !slices.Contains(c.Profile, os.Getenv(api.ProfileEnv)) {
+		return false
+	}
+	if c.Not != nil && c.Not.Holds() {
+		return false
+	}
+	return true
+}
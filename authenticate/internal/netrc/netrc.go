@@ -0,0 +1,205 @@
+// Package netrc parses .netrc files, as used by curl, git and Bazel.
+package netrc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/tweag/credential-helper/agent/locate"
+)
+
+// Entry is a machine (or default) entry of a netrc file.
+type Entry struct {
+	// Machine is the host name of the entry. It is empty for the default entry.
+	Machine  string
+	Login    string
+	Password string
+	Account  string
+}
+
+// File is a parsed netrc file.
+type File struct {
+	Entries []Entry
+	// Default is the default entry, if any.
+	Default *Entry
+}
+
+// DefaultPath returns the path of the netrc file of the user.
+// Like curl and Bazel, it respects $NETRC and falls back to ~/.netrc (~/_netrc on Windows).
+func DefaultPath() string {
+	if path, ok := os.LookupEnv("NETRC"); ok && len(path) > 0 {
+		return path
+	}
+	name := ".netrc"
+	if runtime.GOOS == "windows" {
+		name = "_netrc"
+	}
+	return filepath.Join(locate.ExpandPath("~"), name)
+}
+
+// ReadFile parses the netrc file at path.
+// An empty path reads the file at DefaultPath.
+func ReadFile(path string) (File, error) {
+	if len(path) == 0 {
+		path = DefaultPath()
+	}
+	file, err := os.Open(locate.ExpandPath(path))
+	if err != nil {
+		return File{}, err
+	}
+	defer file.Close()
+	return Parse(file)
+}
+
+// Parse parses the content of a netrc file.
+func Parse(r io.Reader) (File, error) {
+	var tokens []string
+	scanner := bufio.NewScanner(r)
+	inMacro := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inMacro {
+			// a macro definition ends with an empty line
+			inMacro = len(strings.TrimSpace(line)) > 0
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		fields, err := splitTokens(line)
+		if err != nil {
+			return File{}, err
+		}
+		if i := slices.Index(fields, "macdef"); i >= 0 {
+			// the rest of the line is the macro name, followed by the macro body
+			tokens = append(tokens, fields[:i]...)
+			inMacro = true
+			continue
+		}
+		tokens = append(tokens, fields...)
+	}
+	if err := scanner.Err(); err != nil {
+		return File{}, err
+	}
+
+	var parsed File
+	var current *Entry
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		switch token {
+		case "machine", "default":
+			if current != nil {
+				parsed.add(current)
+			}
+			current = &Entry{}
+			if token == "default" {
+				continue
+			}
+		case "login", "password", "account":
+			if current == nil {
+				return File{}, fmt.Errorf("%s outside of a machine entry", token)
+			}
+		default:
+			return File{}, fmt.Errorf("unexpected token %q", token)
+		}
+		if i+1 >= len(tokens) {
+			return File{}, fmt.Errorf("missing value for %s", token)
+		}
+		i++
+		value := tokens[i]
+		switch token {
+		case "machine":
+			current.Machine = value
+		case "login":
+			current.Login = value
+		case "password":
+			current.Password = value
+		case "account":
+			current.Account = value
+		}
+	}
+	if current != nil {
+		parsed.add(current)
+	}
+	return parsed, nil
+}
+
+// splitTokens splits a line at whitespace.
+// Like curl, a token may be enclosed in double quotes to contain whitespace.
+// Quoted tokens support the escapes \", \\, \n, \r and \t.
+func splitTokens(line string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(line); {
+		switch {
+		case line[i] == ' ' || line[i] == '\t' || line[i] == '\r':
+			i++
+		case line[i] == '"':
+			var token strings.Builder
+			i++
+			for ; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) {
+					i++
+					switch line[i] {
+					case 'n':
+						token.WriteByte('\n')
+					case 'r':
+						token.WriteByte('\r')
+					case 't':
+						token.WriteByte('\t')
+					default:
+						token.WriteByte(line[i])
+					}
+					continue
+				}
+				token.WriteByte(line[i])
+			}
+			if i >= len(line) {
+				return nil, errors.New("unterminated quoted token")
+			}
+			i++
+			tokens = append(tokens, token.String())
+		default:
+			end := strings.IndexAny(line[i:], " \t\r")
+			if end < 0 {
+				end = len(line) - i
+			}
+			tokens = append(tokens, line[i:i+end])
+			i += end
+		}
+	}
+	return tokens, nil
+}
+
+func (f *File) add(entry *Entry) {
+	if len(entry.Machine) == 0 {
+		// only the first default entry is used
+		if f.Default == nil {
+			f.Default = entry
+		}
+		return
+	}
+	f.Entries = append(f.Entries, *entry)
+}
+
+// ErrNoEntry is returned by Find if neither a machine entry nor a default entry exists.
+var ErrNoEntry = errors.New("no matching netrc entry")
+
+// Find returns the first entry for the host (case-insensitive), or the default entry.
+func (f File) Find(host string) (Entry, error) {
+	for _, entry := range f.Entries {
+		if strings.EqualFold(entry.Machine, host) {
+			return entry, nil
+		}
+	}
+	if f.Default != nil {
+		return *f.Default, nil
+	}
+	return Entry{}, fmt.Errorf("%w for %s", ErrNoEntry, host)
+}
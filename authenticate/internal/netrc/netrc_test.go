@@ -0,0 +1,117 @@
+package netrc
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		name    string
+		content string
+		want    File
+		err     string
+	}{
+		{
+			name: "machines on one or more lines",
+			content: `# comment
+machine a.example.com login alice password s3cret
+machine b.example.com
+  login bob
+  password hunter2
+  account acme
+`,
+			want: File{Entries: []Entry{
+				{Machine: "a.example.com", Login: "alice", Password: "s3cret"},
+				{Machine: "b.example.com", Login: "bob", Password: "hunter2", Account: "acme"},
+			}},
+		},
+		{
+			name: "default entry",
+			content: `machine a.example.com login alice password s3cret
+default login anonymous password guest
+default login ignored password ignored
+`,
+			want: File{
+				Entries: []Entry{{Machine: "a.example.com", Login: "alice", Password: "s3cret"}},
+				Default: &Entry{Login: "anonymous", Password: "guest"},
+			},
+		},
+		{
+			name: "macdef",
+			content: `machine a.example.com login alice password s3cret macdef init
+cd /pub
+machine fake login inside password macro
+
+machine b.example.com login bob password hunter2
+`,
+			want: File{Entries: []Entry{
+				{Machine: "a.example.com", Login: "alice", Password: "s3cret"},
+				{Machine: "b.example.com", Login: "bob", Password: "hunter2"},
+			}},
+		},
+		{
+			name:    "quoted tokens",
+			content: `machine a.example.com login "alice smith" password "with \"quotes\" and \\ backslash"` + "\n",
+			want: File{Entries: []Entry{
+				{Machine: "a.example.com", Login: "alice smith", Password: `with "quotes" and \ backslash`},
+			}},
+		},
+		{
+			name:    "empty",
+			content: "",
+			want:    File{},
+		},
+		{
+			name:    "unterminated quote",
+			content: `machine a.example.com password "s3cret`,
+			err:     "unterminated quoted token",
+		},
+		{
+			name:    "login outside of machine",
+			content: "login alice",
+			err:     "outside of a machine entry",
+		},
+		{
+			name:    "missing value",
+			content: "machine a.example.com login",
+			err:     "missing value for login",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Parse(strings.NewReader(tc.content))
+			if len(tc.err) > 0 {
+				assert.ErrorContains(t, err, tc.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestFind(t *testing.T) {
+	file, err := Parse(strings.NewReader("machine A.example.com login alice password s3cret\n"))
+	assert.NoError(t, err)
+	entry, err := file.Find("a.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", entry.Login)
+	_, err = file.Find("b.example.com")
+	assert.True(t, errors.Is(err, ErrNoEntry))
+
+	file.Default = &Entry{Login: "anonymous"}
+	entry, err = file.Find("b.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "anonymous", entry.Login)
+}
+
+func TestReadFileMissing(t *testing.T) {
+	_, err := ReadFile(filepath.Join(t.TempDir(), "missing"))
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+}
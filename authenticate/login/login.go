@@ -0,0 +1,116 @@
+// Package login implements interactive logins for the lookup chain of a helper config.
+package login
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+
+	"github.com/tweag/credential-helper/api"
+	"github.com/tweag/credential-helper/authenticate/internal/lookupchain"
+)
+
+// Login runs the interactive login (OAuth 2.0 device authorization grant) of the oauth2 sources
+// in the lookup chains found in the helper config of the context.
+// Instructions for the user are written to out.
+func Login(ctx context.Context, out io.Writer) error {
+	chain, err := chainFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	return chain.Login(ctx, out)
+}
+
+// Logout makes the sources in the lookup chains found in the helper config of the context forget their credentials.
+// oauth2 sources delete their refresh token and git-credential sources reject the credential ("git credential reject").
+func Logout(ctx context.Context, out io.Writer) error {
+	chain, err := chainFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	return chain.Logout(out)
+}
+
+// chainFromContext returns a lookup chain with the entries of all lookup chains in the helper config of the context.
+// Besides the top-level lookup_chain, this includes chains nested in the config of other helpers,
+// like the children of the composite helper.
+func chainFromContext(ctx context.Context) (*lookupchain.LookupChain, error) {
+	rawConfig, ok := ctx.Value(api.HelperConfigKey).([]byte)
+	if !ok {
+		return nil, errors.New("no helper config with a lookup chain found for this uri")
+	}
+	var entries []json.RawMessage
+	// other fields of the helper config are defined by the helper and only searched for lookup chains
+	if err := collectChains(rawConfig, &entries); err != nil {
+		return nil, fmt.Errorf("reading lookup chain from helper config: %w", err)
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("the helper config for this uri has no lookup_chain")
+	}
+	var cfg lookupchain.Config
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, entry); err != nil {
+			return nil, fmt.Errorf("reading lookup chain from helper config: %w", err)
+		}
+		// helpers often share sources, which only need a single login
+		if seen[compact.String()] {
+			continue
+		}
+		seen[compact.String()] = true
+		var configEntry lookupchain.ConfigEntry
+		if err := json.Unmarshal(entry, &configEntry); err != nil {
+			return nil, fmt.Errorf("reading lookup chain from helper config: %w", err)
+		}
+		cfg = append(cfg, configEntry)
+	}
+	return lookupchain.New(cfg), nil
+}
+
+// collectChains appends the entries of every lookup_chain array found in the json value to entries.
+// Objects are searched in the order of their keys, so the result doesn't depend on map iteration.
+func collectChains(raw json.RawMessage, entries *[]json.RawMessage) error {
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) == 0 {
+		return nil
+	}
+	switch trimmed[0] {
+	case '{':
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(trimmed, &object); err != nil {
+			return err
+		}
+		if chain, ok := object["lookup_chain"]; ok {
+			var chainEntries []json.RawMessage
+			if err := json.Unmarshal(chain, &chainEntries); err != nil {
+				return fmt.Errorf("lookup_chain: %w", err)
+			}
+			*entries = append(*entries, chainEntries...)
+		}
+		for _, key := range slices.Sorted(maps.Keys(object)) {
+			if key == "lookup_chain" {
+				continue
+			}
+			if err := collectChains(object[key], entries); err != nil {
+				return err
+			}
+		}
+	case '[':
+		var array []json.RawMessage
+		if err := json.Unmarshal(trimmed, &array); err != nil {
+			return err
+		}
+		for _, element := range array {
+			if err := collectChains(element, entries); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
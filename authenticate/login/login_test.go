@@ -0,0 +1,46 @@
+package login
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tweag/credential-helper/api"
+)
+
+func TestCollectChains(t *testing.T) {
+	// lookup chains of the children of a composite helper
+	config := `{
+  "mode": "first",
+  "helpers": [
+    {"helper": "github", "config": {"lookup_chain": [{"source": "env", "name": "GH_TOKEN"}]}},
+    {"helper": "oauth2", "config": {"token_url": "https://auth.acme.corp/token", "lookup_chain": [{"source": "oauth2", "client_id": "bazel"}]}}
+  ],
+  "lookup_chain": [{"source": "keyring", "service": "top-level"}]
+}`
+	var entries []json.RawMessage
+	assert.NoError(t, collectChains(json.RawMessage(config), &entries))
+	var sources []string
+	for _, entry := range entries {
+		var parsed struct {
+			Source string `json:"source"`
+		}
+		assert.NoError(t, json.Unmarshal(entry, &parsed))
+		sources = append(sources, parsed.Source)
+	}
+	assert.Equal(t, []string{"keyring", "env", "oauth2"}, sources)
+
+	assert.Error(t, collectChains(json.RawMessage(`{"lookup_chain": "env"}`), &entries))
+}
+
+func TestLoginWithoutChain(t *testing.T) {
+	ctx := context.WithValue(context.Background(), api.HelperConfigKey, []byte(`{"helpers": [{"helper": "github", "config": {}}]}`))
+	assert.ErrorContains(t, Login(ctx, io.Discard), "has no lookup_chain")
+	assert.ErrorContains(t, Logout(ctx, io.Discard), "has no lookup_chain")
+
+	// nested chains are found, even if they have nothing to log in to
+	ctx = context.WithValue(context.Background(), api.HelperConfigKey, []byte(`{"helpers": [{"helper": "github", "config": {"lookup_chain": [{"source": "env", "name": "GH_TOKEN"}]}}]}`))
+	assert.ErrorContains(t, Login(ctx, io.Discard), "no oauth2 source")
+}
@@ -0,0 +1,122 @@
+package netrc
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+
+	"github.com/tweag/credential-helper/api"
+	"github.com/tweag/credential-helper/authenticate/internal/helperconfig"
+	netrcfile "github.com/tweag/credential-helper/authenticate/internal/netrc"
+	"github.com/tweag/credential-helper/logging"
+)
+
+// Netrc is a credential helper that uses the login and password of a matching netrc entry
+// for HTTP Basic authentication, like Bazel does when no credential helper is configured.
+type Netrc struct{}
+
+// CacheKey returns a cache key for the given request.
+// For netrc, no cache key is returned (do not cache).
+// Reading the netrc file is cheap and changes to the file should apply immediately.
+func (n *Netrc) CacheKey(req api.GetCredentialsRequest) string {
+	return ""
+}
+
+func (n *Netrc) Resolver(ctx context.Context) (api.Resolver, error) {
+	cfg, err := configFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting configuration fragment for netrc helper: %w", err)
+	}
+	return &NetrcResolver{config: cfg}, nil
+}
+
+func (n *Netrc) SetupInstructionsForURI(ctx context.Context, uri string) string {
+	cfg, err := configFromContext(ctx)
+	if err != nil {
+		return fmt.Sprintf("%s uses the netrc helper, but due to a configuration parsing issue, no further setup instructions are available: %v", uri, err)
+	}
+	path := cfg.Path
+	if len(path) == 0 {
+		path = netrcfile.DefaultPath()
+	}
+	host := "<host>"
+	if parsedURL, err := url.Parse(uri); err == nil {
+		host = parsedURL.Hostname()
+	}
+
+	var status string
+	_, err = entryFor(cfg, host)
+	switch {
+	case err == nil:
+		status = "FOUND"
+	case errors.Is(err, netrcfile.ErrNoEntry):
+		status = "NOT FOUND"
+	default:
+		status = fmt.Sprintf("ERROR: %v", err)
+	}
+
+	return fmt.Sprintf(`%s uses the netrc helper.
+
+The helper sends the login and password of the matching netrc entry using HTTP Basic authentication.
+Add an entry for %s to the netrc file %s (status: %s):
+
+    machine %s login <login> password <password>
+
+Tip: the netrc file contains secrets. Make sure it is only readable by you:
+
+    $ chmod 600 %s`, uri, host, path, status, host, path)
+}
+
+type NetrcResolver struct {
+	config configFragment
+}
+
+// Get implements the get command of the credential-helper spec:
+//
+// https://github.com/EngFlow/credential-helper-spec/blob/main/spec.md#get
+func (n *NetrcResolver) Get(ctx context.Context, req api.GetCredentialsRequest) (api.GetCredentialsResponse, error) {
+	parsedURL, err := url.Parse(req.URI)
+	if err != nil {
+		return api.GetCredentialsResponse{}, err
+	}
+	entry, err := entryFor(n.config, parsedURL.Hostname())
+	if errors.Is(err, netrcfile.ErrNoEntry) || errors.Is(err, fs.ErrNotExist) {
+		// like Bazel, send no credentials to hosts without a netrc entry
+		logging.Debugf("no netrc entry for %s - returning empty response: %v", parsedURL.Hostname(), err)
+		return api.GetCredentialsResponse{}, nil
+	}
+	if err != nil {
+		return api.GetCredentialsResponse{}, err
+	}
+	if len(entry.Login) == 0 && len(entry.Password) == 0 {
+		logging.Debugf("netrc entry for %s has no login and password - returning empty response", parsedURL.Hostname())
+		return api.GetCredentialsResponse{}, nil
+	}
+	auth := base64.StdEncoding.EncodeToString([]byte(entry.Login + ":" + entry.Password))
+	return api.GetCredentialsResponse{
+		Headers: map[string][]string{
+			"Authorization": {"Basic " + auth},
+		},
+	}, nil
+}
+
+func entryFor(cfg configFragment, host string) (netrcfile.Entry, error) {
+	file, err := netrcfile.ReadFile(cfg.Path)
+	if err != nil {
+		return netrcfile.Entry{}, fmt.Errorf("reading netrc file: %w", err)
+	}
+	return file.Find(host)
+}
+
+type configFragment struct {
+	// Path is the path of the netrc file. It is subject to prefix expansion.
+	// Defaults to $NETRC or ~/.netrc (~/_netrc on Windows).
+	Path string `json:"path,omitempty"`
+}
+
+func configFromContext(ctx context.Context) (configFragment, error) {
+	return helperconfig.FromContext(ctx, configFragment{})
+}
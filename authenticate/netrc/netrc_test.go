@@ -0,0 +1,40 @@
+package netrc
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tweag/credential-helper/api"
+)
+
+func TestGet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "netrc")
+	assert.NoError(t, os.WriteFile(path, []byte("machine files.acme.corp login alice password s3cret\n"), 0o600))
+	get := func(path, uri string) (api.GetCredentialsResponse, error) {
+		ctx := context.WithValue(context.Background(), api.HelperConfigKey, []byte(fmt.Sprintf(`{"path": %q}`, path)))
+		resolver, err := (&Netrc{}).Resolver(ctx)
+		assert.NoError(t, err)
+		return resolver.Get(ctx, api.GetCredentialsRequest{URI: uri})
+	}
+
+	resp, err := get(path, "https://files.acme.corp/a.tar.gz")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Basic YWxpY2U6czNjcmV0"}, resp.Headers["Authorization"])
+
+	// hosts without an entry and missing files get no credentials, like in Bazel
+	resp, err = get(path, "https://other.acme.corp/a.tar.gz")
+	assert.NoError(t, err)
+	assert.Empty(t, resp.Headers)
+	resp, err = get(filepath.Join(t.TempDir(), "missing"), "https://files.acme.corp/a.tar.gz")
+	assert.NoError(t, err)
+	assert.Empty(t, resp.Headers)
+
+	// broken files are still reported
+	assert.NoError(t, os.WriteFile(path, []byte("login alice\n"), 0o600))
+	_, err = get(path, "https://files.acme.corp/a.tar.gz")
+	assert.ErrorContains(t, err, "outside of a machine entry")
+}
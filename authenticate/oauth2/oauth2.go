@@ -0,0 +1,208 @@
+package oauth2
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tweag/credential-helper/api"
+	"github.com/tweag/credential-helper/authenticate/internal/helperconfig"
+	"github.com/tweag/credential-helper/authenticate/internal/lookupchain"
+	"github.com/tweag/credential-helper/logging"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// Bindings of the lookup chain
+const (
+	BindingClientID     = "client_id"
+	BindingClientSecret = "client_secret"
+)
+
+// Values of configFragment.AuthStyle
+const (
+	AuthStyleAuto   = "auto"
+	AuthStyleHeader = "header"
+	AuthStyleParams = "params"
+)
+
+// tokenPlaceholder is replaced by the access token in the header format.
+const tokenPlaceholder = "{{token}}"
+
+// OAuth2 is a credential helper that obtains access tokens using the OAuth 2.0 client credentials grant (RFC 6749, section 4.4).
+type OAuth2 struct{}
+
+// CacheKey returns a cache key for the given request.
+// Without the helper config, no cache key is returned (do not cache).
+func (o *OAuth2) CacheKey(req api.GetCredentialsRequest) string {
+	return ""
+}
+
+// CacheKeyWithContext returns a cache key that identifies the token, not the requested url.
+// All urls using the same token endpoint, client and scopes share one cached token.
+func (o *OAuth2) CacheKeyWithContext(ctx context.Context, req api.GetCredentialsRequest) string {
+	cfg, err := configFromContext(ctx)
+	if err != nil || len(cfg.TokenURL) == 0 {
+		return ""
+	}
+	scopes := slices.Clone(cfg.Scopes)
+	slices.Sort(scopes)
+	key := []string{cfg.TokenURL, cfg.ClientID, strings.Join(scopes, " "), cfg.Audience, cfg.HeaderName, cfg.HeaderFormat}
+	for i := range key {
+		key[i] = strconv.Quote(key[i])
+	}
+	return "oauth2:" + strings.Join(key, ",")
+}
+
+func (o *OAuth2) Resolver(ctx context.Context) (api.Resolver, error) {
+	cfg, err := configFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting configuration fragment for oauth2 helper: %w", err)
+	}
+	return &OAuth2Resolver{config: cfg}, nil
+}
+
+func (o *OAuth2) SetupInstructionsForURI(ctx context.Context, uri string) string {
+	cfg, err := configFromContext(ctx)
+	if err != nil {
+		return fmt.Sprintf("%s uses the oauth2 helper, but due to a configuration parsing issue, no further setup instructions are available: %v", uri, err)
+	}
+	chain := lookupchain.New(cfg.LookupChain)
+	var clientIDInstructions string
+	if len(cfg.ClientID) == 0 {
+		clientIDInstructions = "\n\n" + chain.SetupInstructions(BindingClientID, "id of the OAuth 2.0 client")
+	}
+	return fmt.Sprintf(`%s uses the oauth2 helper.
+
+The helper requests access tokens from %s using the OAuth 2.0 client credentials grant.
+Register a client with the issuer and provide its credentials.%s
+
+%s`, uri, cfg.TokenURL, clientIDInstructions, chain.SetupInstructions(BindingClientSecret, "secret of the OAuth 2.0 client"))
+}
+
+type OAuth2Resolver struct {
+	config configFragment
+}
+
+// Get implements the get command of the credential-helper spec:
+//
+// https://github.com/EngFlow/credential-helper-spec/blob/main/spec.md#get
+func (o *OAuth2Resolver) Get(ctx context.Context, req api.GetCredentialsRequest) (api.GetCredentialsResponse, error) {
+	cfg := o.config
+	if len(cfg.TokenURL) == 0 {
+		return api.GetCredentialsResponse{}, fmt.Errorf("oauth2 helper needs a token_url")
+	}
+	if !strings.Contains(cfg.HeaderFormat, tokenPlaceholder) {
+		return api.GetCredentialsResponse{}, fmt.Errorf("header_format %q must contain %s", cfg.HeaderFormat, tokenPlaceholder)
+	}
+	authStyle, err := authStyle(cfg.AuthStyle)
+	if err != nil {
+		return api.GetCredentialsResponse{}, err
+	}
+
+	chain := lookupchain.New(cfg.LookupChain)
+	chain.Prefetch(BindingClientID, BindingClientSecret)
+	clientID := lookupchain.Result{Value: cfg.ClientID}
+	if len(clientID.Value) == 0 {
+		clientID, err = chain.LookupResult(BindingClientID)
+		if err != nil {
+			return api.GetCredentialsResponse{}, fmt.Errorf("looking up client id: %w", err)
+		}
+	}
+	clientSecret, err := chain.LookupResult(BindingClientSecret)
+	if err != nil {
+		return api.GetCredentialsResponse{}, fmt.Errorf("looking up client secret: %w", err)
+	}
+
+	clientConfig := clientcredentials.Config{
+		ClientID:     clientID.Value,
+		ClientSecret: clientSecret.Value,
+		TokenURL:     cfg.TokenURL,
+		Scopes:       cfg.Scopes,
+		AuthStyle:    authStyle,
+	}
+	if len(cfg.Audience) > 0 {
+		clientConfig.EndpointParams = url.Values{"audience": {cfg.Audience}}
+	}
+	token, err := clientConfig.Token(ctx)
+	if err != nil {
+		return api.GetCredentialsResponse{}, fmt.Errorf("requesting access token from %s: %w", cfg.TokenURL, err)
+	}
+
+	resp := api.GetCredentialsResponse{
+		Headers: map[string][]string{
+			cfg.HeaderName: {strings.ReplaceAll(cfg.HeaderFormat, tokenPlaceholder, token.AccessToken)},
+		},
+	}
+	// tokens without expiry are not cached
+	expires := lookupchain.EarliestExpiry(clientID, clientSecret, lookupchain.Result{Expires: token.Expiry})
+	if !expires.IsZero() {
+		logging.Debugf("oauth2 access token from %s expires at %s", cfg.TokenURL, expires.Format(time.RFC3339))
+		resp.Expires = expires.UTC().Format(time.RFC3339)
+	}
+	return resp, nil
+}
+
+func authStyle(style string) (oauth2.AuthStyle, error) {
+	switch style {
+	case AuthStyleAuto:
+		return oauth2.AuthStyleAutoDetect, nil
+	case AuthStyleHeader:
+		return oauth2.AuthStyleInHeader, nil
+	case AuthStyleParams:
+		return oauth2.AuthStyleInParams, nil
+	}
+	return oauth2.AuthStyleAutoDetect, fmt.Errorf(`unknown auth_style %q. Possible values are "auto", "header" and "params"`, style)
+}
+
+type configFragment struct {
+	// TokenURL is the token endpoint of the issuer.
+	TokenURL string `json:"token_url"`
+	// ClientID is the id of the client.
+	// If empty, it is looked up from the client_id binding of the lookup chain.
+	ClientID string `json:"client_id,omitempty"`
+	// Scopes are the requested scopes.
+	Scopes []string `json:"scopes,omitempty"`
+	// Audience is the optional audience of the requested token (used by issuers like Auth0).
+	Audience string `json:"audience,omitempty"`
+	// AuthStyle is how the client authenticates to the token endpoint:
+	// "auto" (default), "header" (HTTP Basic authentication) or "params" (form parameters).
+	AuthStyle string `json:"auth_style,omitempty"`
+	// HeaderName is the name of the header that carries the token. Defaults to "Authorization".
+	HeaderName string `json:"header_name,omitempty"`
+	// HeaderFormat is the value of the header. {{token}} is replaced by the access token.
+	// Defaults to "Bearer {{token}}".
+	HeaderFormat string `json:"header_format,omitempty"`
+	// LookupChain defines the order in which the client id and the client secret are looked up from sources.
+	// It defaults to the sources "env", "keyring".
+	LookupChain lookupchain.Config `json:"lookup_chain"`
+}
+
+func configFromContext(ctx context.Context) (configFragment, error) {
+	return helperconfig.FromContext(ctx, configFragment{
+		AuthStyle:    AuthStyleAuto,
+		HeaderName:   "Authorization",
+		HeaderFormat: "Bearer " + tokenPlaceholder,
+		LookupChain: lookupchain.Default([]lookupchain.Source{
+			&lookupchain.Env{
+				Source:  "env",
+				Name:    "OAUTH2_CLIENT_ID",
+				Binding: BindingClientID,
+			},
+			&lookupchain.Env{
+				Source:  "env",
+				Name:    "OAUTH2_CLIENT_SECRET",
+				Binding: BindingClientSecret,
+			},
+			&lookupchain.Keyring{
+				Source:  "keyring",
+				Service: "tweag-credential-helper:oauth2_client_secret",
+				Binding: BindingClientSecret,
+			},
+		}),
+	})
+}
@@ -0,0 +1,73 @@
+package oauth2
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tweag/credential-helper/api"
+)
+
+func TestOAuth2(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.NoError(t, r.ParseForm())
+		clientID, clientSecret, ok := r.BasicAuth()
+		if !ok || clientID != "builder" || clientSecret != "s3cret" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error": "invalid_client"}`)
+			return
+		}
+		assert.Equal(t, "client_credentials", r.PostForm.Get("grant_type"))
+		assert.Equal(t, "artifacts:read models:read", r.PostForm.Get("scope"))
+		assert.Equal(t, "https://registry.acme.corp", r.PostForm.Get("audience"))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token": "access", "token_type": "Bearer", "expires_in": 3600}`)
+	}))
+	defer server.Close()
+
+	t.Setenv("OAUTH2_CLIENT_SECRET", "s3cret")
+	config := fmt.Sprintf(`{"token_url": %q, "client_id": "builder", "scopes": ["artifacts:read", "models:read"], "audience": "https://registry.acme.corp", "auth_style": "header"}`, server.URL)
+	ctx := context.WithValue(context.Background(), api.HelperConfigKey, []byte(config))
+	helper := &OAuth2{}
+	resolver, err := helper.Resolver(ctx)
+	assert.NoError(t, err)
+
+	before := time.Now()
+	resp, err := resolver.Get(ctx, api.GetCredentialsRequest{URI: "https://registry.acme.corp/v1/models/foo"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Bearer access"}, resp.Headers["Authorization"])
+	expires, err := time.Parse(time.RFC3339, resp.Expires)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, before.Add(time.Hour), expires, 5*time.Second)
+	assert.Equal(t, 1, requests)
+
+	// the token is cached per token endpoint and scopes, not per url
+	key := helper.CacheKeyWithContext(ctx, api.GetCredentialsRequest{URI: "https://registry.acme.corp/v1/models/foo"})
+	assert.NotEmpty(t, key)
+	assert.Equal(t, key, helper.CacheKeyWithContext(ctx, api.GetCredentialsRequest{URI: "https://registry.acme.corp/v1/models/bar"}))
+	reordered := context.WithValue(context.Background(), api.HelperConfigKey, []byte(fmt.Sprintf(`{"token_url": %q, "client_id": "builder", "scopes": ["models:read", "artifacts:read"], "audience": "https://registry.acme.corp", "auth_style": "header"}`, server.URL)))
+	assert.Equal(t, key, helper.CacheKeyWithContext(reordered, api.GetCredentialsRequest{}))
+	otherScopes := context.WithValue(context.Background(), api.HelperConfigKey, []byte(fmt.Sprintf(`{"token_url": %q, "client_id": "builder", "scopes": ["artifacts:write"]}`, server.URL)))
+	assert.NotEqual(t, key, helper.CacheKeyWithContext(otherScopes, api.GetCredentialsRequest{}))
+
+	// custom header format
+	custom := context.WithValue(context.Background(), api.HelperConfigKey, []byte(fmt.Sprintf(`{"token_url": %q, "client_id": "builder", "scopes": ["artifacts:read", "models:read"], "audience": "https://registry.acme.corp", "header_name": "X-Registry-Token", "header_format": "token={{token}}"}`, server.URL)))
+	resolver, err = helper.Resolver(custom)
+	assert.NoError(t, err)
+	resp, err = resolver.Get(custom, api.GetCredentialsRequest{URI: "https://registry.acme.corp/"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"token=access"}, resp.Headers["X-Registry-Token"])
+
+	t.Setenv("OAUTH2_CLIENT_SECRET", "wrong")
+	resolver, err = helper.Resolver(ctx)
+	assert.NoError(t, err)
+	_, err = resolver.Get(ctx, api.GetCredentialsRequest{URI: "https://registry.acme.corp/"})
+	assert.ErrorContains(t, err, "invalid_client")
+}
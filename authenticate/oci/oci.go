@@ -461,6 +461,7 @@ func (o *OCIResolver) deriveAuthConfig(ctx context.Context, registry, service, r
 	// try to find overrides for the docker auth config
 	// in the lookup chain
 	chain := lookupchain.New(cfg.LookupChain)
+	chain.Prefetch(BindingUsername, BindingPassword, BindingAuth, BindingIdentityToken, BindingRegistryToken)
 	unsername, unsernameErr := chain.Lookup(BindingUsername)
 	password, passwordErr := chain.Lookup(BindingPassword)
 	auth, authErr := chain.Lookup(BindingAuth)
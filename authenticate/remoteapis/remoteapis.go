@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/tweag/credential-helper/api"
 	"github.com/tweag/credential-helper/authenticate/internal/helperconfig"
@@ -112,7 +113,7 @@ func (g *RemoteAPIs) Get(ctx context.Context, req api.GetCredentialsRequest) (ap
 	}
 
 	chain := lookupchain.New(cfg.LookupChain)
-	secret, err := chain.Lookup("default")
+	secret, err := chain.LookupResult("default")
 	if err != nil {
 		return api.GetCredentialsResponse{}, err
 	}
@@ -144,11 +145,16 @@ func (g *RemoteAPIs) Get(ctx context.Context, req api.GetCredentialsRequest) (ap
 		return api.GetCredentialsResponse{}, fmt.Errorf(`unknown auth method %q. Possible values are "header" and "basic_auth"`, cfg.AuthMethod)
 	}
 
-	return api.GetCredentialsResponse{
+	resp := api.GetCredentialsResponse{
 		Headers: map[string][]string{
-			headerName: {secretEncoding(secret)},
+			headerName: {secretEncoding(secret.Value)},
 		},
-	}, nil
+	}
+	// secrets without expiry (like static api keys) are not cached
+	if !secret.Expires.IsZero() {
+		resp.Expires = secret.Expires.UTC().Format(time.RFC3339)
+	}
+	return resp, nil
 }
 
 type configFragment struct {
@@ -125,44 +125,57 @@ func (s *S3Resolver) Get(ctx context.Context, req api.GetCredentialsRequest) (ap
 	}
 
 	chain := lookupchain.New(cfg.LookupChain)
+	isR2 := providerFromHost(parsedURL.Host) == ProviderCloudflareR2
+	bindings := []string{BindigAccessKeyID, BindingSecretAccessKey, BindingSessionToken, BindingRegion}
+	if isR2 {
+		// only R2 uses the cloudflare token, so other providers must not run its sources
+		bindings = append(bindings, BindingCloudflareAPIToken)
+	}
+	chain.Prefetch(bindings...)
 
 	var accessKeyID, secretAccessKey, sessionToken, region string
+	// results of the lookup chain, used to find the earliest expiry of the secrets
+	var results []lookupchain.Result
 
 	if cfg.Region != "" {
 		region = cfg.Region
 	}
 
-	accessKeyIDLookup, err := chain.Lookup(BindigAccessKeyID)
+	accessKeyIDLookup, err := chain.LookupResult(BindigAccessKeyID)
 	if err == nil {
-		accessKeyID = accessKeyIDLookup
+		accessKeyID = accessKeyIDLookup.Value
+		results = append(results, accessKeyIDLookup)
 	} else if lookupchain.IsNotFoundErr(err) {
 		logging.Debugf("access key id lookup: binding %s did not yield any secrets - continuing without", BindigAccessKeyID)
 	} else {
 		logging.Debugf("access key id lookup failed - continuing without: %v", err)
 	}
 
-	if providerFromHost(parsedURL.Host) == ProviderCloudflareR2 {
+	if isR2 {
 		// cloudflare token can be hashed to obtain the secret access key for the S3 API
-		cloudflareAPIToken, err := chain.Lookup(BindingCloudflareAPIToken)
+		cloudflareAPIToken, err := chain.LookupResult(BindingCloudflareAPIToken)
 		if err == nil {
+			results = append(results, cloudflareAPIToken)
 			hasher := sha256.New()
-			hasher.Write([]byte(cloudflareAPIToken))
+			hasher.Write([]byte(cloudflareAPIToken.Value))
 			secretAccessKey = hex.EncodeToString(hasher.Sum(nil))
 		}
 	}
 
-	secretAccessKeyLookup, err := chain.Lookup(BindingSecretAccessKey)
+	secretAccessKeyLookup, err := chain.LookupResult(BindingSecretAccessKey)
 	if err == nil {
-		secretAccessKey = secretAccessKeyLookup
+		secretAccessKey = secretAccessKeyLookup.Value
+		results = append(results, secretAccessKeyLookup)
 	} else if lookupchain.IsNotFoundErr(err) {
 		logging.Debugf("secret access key lookup: binding %s did not yield any secrets - continuing without", BindingSecretAccessKey)
 	} else {
 		logging.Debugf("secret access key lookup failed - continuing without: %v", err)
 	}
 
-	sessionTokenLookup, err := chain.Lookup(BindingSessionToken)
+	sessionTokenLookup, err := chain.LookupResult(BindingSessionToken)
 	if err == nil {
-		sessionToken = sessionTokenLookup
+		sessionToken = sessionTokenLookup.Value
+		results = append(results, sessionTokenLookup)
 	} else if lookupchain.IsNotFoundErr(err) {
 		logging.Debugf("session token lookup: binding %s did not yield any secrets - continuing without", BindingSessionToken)
 	} else {
@@ -219,8 +232,17 @@ func (s *S3Resolver) Get(ctx context.Context, req api.GetCredentialsRequest) (ap
 		return api.GetCredentialsResponse{}, err
 	}
 
+	// the signature must not outlive the credentials used to create it
+	expires := ts.Add(expiresIn)
+	if cred.CanExpire && !cred.Expires.IsZero() {
+		results = append(results, lookupchain.Result{Expires: cred.Expires})
+	}
+	if credentialsExpire := lookupchain.EarliestExpiry(results...); !credentialsExpire.IsZero() && credentialsExpire.Before(expires) {
+		expires = credentialsExpire
+	}
+
 	return api.GetCredentialsResponse{
-		Expires: ts.Add(expiresIn).Format(time.RFC3339),
+		Expires: expires.UTC().Format(time.RFC3339),
 		Headers: httpReq.Header,
 	}, nil
 }
@@ -0,0 +1,103 @@
+package ci
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tweag/credential-helper/api"
+	"github.com/tweag/credential-helper/config"
+	"github.com/tweag/credential-helper/logging"
+)
+
+// IdleTimeout is the idle timeout of the agent when the CI preset is active.
+// A short timeout prevents the agent from outliving the CI job.
+const IdleTimeout = 5 * time.Minute
+
+type provider struct {
+	name string
+	env  string
+	// value is the expected value of env (case-insensitive).
+	// If empty, any non-empty value matches.
+	value string
+}
+
+// providers are checked in order. The generic $CI variable comes last,
+// so that a more specific provider is reported if possible.
+var providers = []provider{
+	{name: "GitHub Actions", env: "GITHUB_ACTIONS", value: "true"},
+	{name: "GitLab CI", env: "GITLAB_CI", value: "true"},
+	{name: "Buildkite", env: "BUILDKITE", value: "true"},
+	{name: "CircleCI", env: "CIRCLECI", value: "true"},
+	{name: "Azure Pipelines", env: "TF_BUILD", value: "true"},
+	{name: "Travis CI", env: "TRAVIS", value: "true"},
+	{name: "Bitbucket Pipelines", env: "BITBUCKET_BUILD_NUMBER"},
+	{name: "Jenkins", env: "JENKINS_URL"},
+	{name: "generic CI", env: "CI", value: "true"},
+}
+
+// Detect returns the name of the CI provider the helper runs in.
+// It returns false if no known CI provider is detected.
+func Detect() (string, bool) {
+	for _, p := range providers {
+		value := os.Getenv(p.env)
+		if len(value) == 0 {
+			continue
+		}
+		if len(p.value) == 0 || strings.EqualFold(value, p.value) {
+			return p.name, true
+		}
+	}
+	return "", false
+}
+
+// ApplyPreset detects the CI provider and applies defaults tailored to CI:
+//   - the agent uses a short idle timeout (unless $CREDENTIAL_HELPER_IDLE_TIMEOUT is set)
+//   - lookup chains skip interactive sources (keyring, oauth2 and git-credential), unless an entry has a when clause
+//   - nothing is written to the syslog
+//   - error messages don't suggest interactive setup commands
+//
+// The preset can be disabled in the config file by setting ci_preset to "off".
+// The detected provider is exported as $CREDENTIAL_HELPER_CI_PROVIDER in any case, so that conditions of lookup chain entries work without the preset.
+// Other settings are passed on using environment variables, so they also apply to the agent process.
+func ApplyPreset(configReader config.ConfigReader) error {
+	name, detected := Detect()
+	if detected {
+		if err := os.Setenv(api.CIProviderEnv, name); err != nil {
+			return err
+		}
+	}
+
+	preset := config.CIPresetAuto
+	if cfg, err := configReader.Read(); err == nil && len(cfg.CIPreset) > 0 {
+		// errors reading the config file are reported when choosing a helper
+		preset = cfg.CIPreset
+	}
+	switch preset {
+	case config.CIPresetAuto:
+	case config.CIPresetOff:
+		logging.Debugf("CI preset disabled by config file")
+		return nil
+	default:
+		return fmt.Errorf(`invalid configuration file: unknown ci_preset %q. Possible values are "auto" and "off"`, preset)
+	}
+
+	if !detected {
+		return nil
+	}
+	logging.Basicf("detected CI provider %s - applying CI preset", name)
+
+	if _, ok := os.LookupEnv(api.IdleTimeoutEnv); !ok {
+		if err := os.Setenv(api.IdleTimeoutEnv, IdleTimeout.String()); err != nil {
+			return err
+		}
+	}
+	logging.DisableSyslog()
+	return os.Setenv(api.CIPresetEnv, "1")
+}
+
+// Active returns true if the CI preset was applied.
+func Active() bool {
+	return len(os.Getenv(api.CIPresetEnv)) > 0
+}
@@ -0,0 +1,51 @@
+package ci
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tweag/credential-helper/api"
+	"github.com/tweag/credential-helper/config"
+)
+
+type staticReader config.Config
+
+func (r staticReader) Read() (config.Config, error) {
+	return config.Config(r), nil
+}
+
+func TestApplyPreset(t *testing.T) {
+	// hide the CI provider running this test
+	for _, p := range providers {
+		t.Setenv(p.env, "")
+	}
+	t.Setenv(api.IdleTimeoutEnv, "1h")
+	tests := []struct {
+		name         string
+		env          string
+		preset       string
+		wantProvider string
+		wantActive   bool
+	}{
+		{name: "no CI", preset: config.CIPresetAuto},
+		{name: "auto", env: "GITLAB_CI", preset: config.CIPresetAuto, wantProvider: "GitLab CI", wantActive: true},
+		{name: "default", env: "GITLAB_CI", wantProvider: "GitLab CI", wantActive: true},
+		// conditions of lookup chain entries use the provider, even if the preset is off
+		{name: "off", env: "GITLAB_CI", preset: config.CIPresetOff, wantProvider: "GitLab CI"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(api.CIProviderEnv, "")
+			t.Setenv(api.CIPresetEnv, "")
+			if len(tt.env) > 0 {
+				t.Setenv(tt.env, "true")
+			}
+			assert.NoError(t, ApplyPreset(staticReader{CIPreset: tt.preset}))
+			assert.Equal(t, tt.wantProvider, os.Getenv(api.CIProviderEnv))
+			assert.Equal(t, tt.wantActive, Active())
+		})
+	}
+
+	assert.ErrorContains(t, ApplyPreset(staticReader{CIPreset: "sometimes"}), "unknown ci_preset")
+}
@@ -2,23 +2,71 @@ package util
 
 import (
 	"context"
+	"os"
 
 	"github.com/tweag/credential-helper/api"
 	"github.com/tweag/credential-helper/config"
 	"github.com/tweag/credential-helper/logging"
 )
 
-func Configure(ctx context.Context, helperFactory api.HelperFactory, configReader config.ConfigReader, uri string) (context.Context, api.Helper) {
+// Selection describes how the helper for a uri was chosen.
+type Selection struct {
+	// Rule is the url config that selected the helper.
+	// It is nil if no config file exists or no url config matched.
+	Rule *config.URLConfig
+	// URI is the uri that should be passed to the helper.
+	// It differs from the requested uri if a rewritten url was mapped back to its upstream url.
+	URI string
+	// Profile is the name of the active profile.
+	// It is empty if no profile is active.
+	Profile string
+}
+
+// Configure chooses the helper for the uri.
+// If a config file exists, the helper is chosen from it.
+// Otherwise, the helper factory is used.
+func Configure(ctx context.Context, helperFactory api.HelperFactory, configReader config.ConfigReader, uri string) (context.Context, api.Helper, Selection) {
+	selection := Selection{URI: uri}
 	cfg, err := configReader.Read()
 	if err == nil {
 		logging.Debugf("found config file and choosing helper from it")
+		selection.Profile, err = cfg.ActiveProfile()
+		if err != nil {
+			logging.Fatalf("%v", err)
+		}
+		cfg, err = cfg.WithProfile(selection.Profile)
+		if err != nil {
+			logging.Fatalf("%v", err)
+		}
+		if len(selection.Profile) > 0 {
+			// make the active profile visible to helpers and lookup chain sources
+			if err := os.Setenv(api.ProfileEnv, selection.Profile); err != nil {
+				logging.Fatalf("setting $%s: %v", api.ProfileEnv, err)
+			}
+		}
+		resolvedURI, blocked, err := cfg.Rewrite.ResolveURI(uri)
+		if err != nil {
+			logging.Fatalf("%v", err)
+		}
+		if blocked {
+			logging.Basicf("host of %s is blocked by the downloader config - returning empty response", uri)
+			// a nil url config selects the null helper
+			helper, _ := config.HelperFor(nil)
+			return ctx, helper, selection
+		}
+		selection.URI = resolvedURI
 		helperFactory = func(uri string) (api.Helper, error) {
-			helper, helperConfig, err := cfg.FindHelper(uri)
+			var err error
+			selection.Rule, err = cfg.FindRule(uri)
 			if err != nil {
 				return nil, err
 			}
-			if len(helperConfig) > 0 {
-				ctx = context.WithValue(ctx, api.HelperConfigKey, helperConfig)
+			helper, err := config.HelperFor(selection.Rule)
+			if err != nil {
+				return nil, err
+			}
+			if selection.Rule != nil && len(selection.Rule.Config) > 0 {
+				ctx = context.WithValue(ctx, api.HelperConfigKey, []byte(selection.Rule.Config))
 			}
 			return helper, nil
 		}
@@ -26,10 +74,31 @@ func Configure(ctx context.Context, helperFactory api.HelperFactory, configReade
 		logging.Fatalf("reading config: %v", err)
 	}
 
-	authenticator, err := helperFactory(uri)
+	if err := os.Setenv(api.RequestURIEnv, selection.URI); err != nil {
+		logging.Fatalf("setting $%s: %v", api.RequestURIEnv, err)
+	}
+	authenticator, err := helperFactory(selection.URI)
 	if err != nil {
 		logging.Fatalf("%v", err)
 	}
 
-	return ctx, authenticator
+	return ctx, authenticator, selection
+}
+
+// CacheKey returns the cache key of the helper for the request.
+// If the helper was chosen by a url config, the key is namespaced by a hash of that url config
+// and by the active profile.
+// This keeps credentials of different url configs and profiles apart and invalidates them when the config changes.
+func CacheKey(ctx context.Context, helper api.Helper, selection Selection, req api.GetCredentialsRequest) string {
+	cacheKey := api.CacheKeyFor(ctx, helper, req)
+	if len(cacheKey) == 0 {
+		return cacheKey
+	}
+	if selection.Rule != nil {
+		cacheKey += "#config=" + selection.Rule.CacheNamespace()
+	}
+	if len(selection.Profile) > 0 {
+		cacheKey += "#profile=" + selection.Profile
+	}
+	return cacheKey
 }
@@ -2,6 +2,7 @@ package root
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,8 +15,10 @@ import (
 	"github.com/tweag/credential-helper/agent"
 	"github.com/tweag/credential-helper/agent/locate"
 	"github.com/tweag/credential-helper/api"
+	"github.com/tweag/credential-helper/authenticate/headerrules"
 	"github.com/tweag/credential-helper/cache"
 	"github.com/tweag/credential-helper/cmd/installer"
+	"github.com/tweag/credential-helper/cmd/internal/ci"
 	"github.com/tweag/credential-helper/cmd/internal/util"
 	"github.com/tweag/credential-helper/cmd/setup"
 	"github.com/tweag/credential-helper/config"
@@ -26,12 +29,23 @@ import (
 // version using the linker's stamping feature (i.e., using a `-X` argument)
 var version = "0.0.0"
 
+const (
+	// negativeCacheTTL is the time an empty response is cached after a helper failed with on_error "warn_once".
+	negativeCacheTTL = 5 * time.Minute
+	// warnInterval is the minimum time between two warnings for the same cache key with on_error "warn_once".
+	warnInterval = time.Hour
+)
+
 const usage = `Usage: credential-helper [COMMAND] [ARGS...]
 
 Commands:
   get            get credentials in the form of http headers for the uri provided on stdin and print result to stdout (see https://github.com/EngFlow/credential-helper-spec for more information)
   setup-uri      prints setup instructions for a given uri
+  explain        explains which profile, url config and helper are used for a given uri
   setup-keyring  stores a secret in the system keyring
+  login          logs in to the OAuth 2.0 issuer configured for a given uri
+  logout         forgets the OAuth 2.0 refresh tokens and git credentials for a given uri
+  bundle         creates and edits encrypted bundles of secrets
   version        displays the version of this tool`
 
 func Run(ctx context.Context, helperFactory api.HelperFactory, newCache api.NewCache, args []string) {
@@ -47,14 +61,25 @@ func Run(ctx context.Context, helperFactory api.HelperFactory, newCache api.NewC
 	if err := locate.SetupEnvironment(); err != nil {
 		logging.Fatalf("setting up process environment: %v", err)
 	}
+	if err := ci.ApplyPreset(config.OSReader{}); err != nil {
+		logging.Fatalf("applying CI preset: %v", err)
+	}
 	command := args[1]
 	switch command {
 	case "get":
 		clientProcess(ctx, helperFactory)
 	case "setup-uri":
 		setup.URIProcess(args[2:], helperFactory, config.OSReader{})
+	case "explain":
+		setup.ExplainProcess(args[2:], helperFactory, config.OSReader{})
 	case "setup-keyring":
 		setup.KeyringProcess(args[2:])
+	case "bundle":
+		setup.BundleProcess(args[2:])
+	case "login":
+		setup.LoginProcess(args[2:], helperFactory, config.OSReader{})
+	case "logout":
+		setup.LogoutProcess(args[2:], helperFactory, config.OSReader{})
 	case "agent-launch":
 		agentProcess(ctx, newCache)
 	case "agent-shutdown":
@@ -91,11 +116,17 @@ func foreground(ctx context.Context, cache api.Cache, helperFactory api.HelperFa
 	// so there is no reliable way to ensure that the stderr
 	// of the credential helper is visible to the user.
 	// Therefore, we log every request to syslog in debug mode.
-	logging.SyslogDebugf(req.URI)
+	logging.SyslogDebugf("%s", req.URI)
+
+	ctx, authenticator, selection := util.Configure(ctx, helperFactory, configReader, req.URI)
+	req.URI = selection.URI
 
-	ctx, authenticator := util.Configure(ctx, helperFactory, configReader, req.URI)
+	policy, err := errorPolicy(selection.Rule)
+	if err != nil {
+		logging.Fatalf("%v", err)
+	}
 
-	cacheKey := authenticator.CacheKey(req)
+	cacheKey := util.CacheKey(ctx, authenticator, selection, req)
 	if len(cacheKey) == 0 {
 		logging.Basicf("no cache key returned - not caching")
 	} else {
@@ -117,22 +148,66 @@ func foreground(ctx context.Context, cache api.Cache, helperFactory api.HelperFa
 	}
 
 	resolver, err := authenticator.Resolver(ctx)
+	if err == nil {
+		resp, err = resolver.Get(ctx, req)
+	} else {
+		err = fmt.Errorf("instantiating resolver: %w", err)
+	}
+	if err == nil && selection.Rule != nil {
+		resp, err = headerrules.Apply(ctx, selection.Rule.Headers, resp)
+		if err != nil {
+			err = fmt.Errorf("applying header rules: %w", err)
+		}
+	}
 	if err != nil {
-		logging.Fatalf("instantiating resolver: %s", err)
+		resp, err = onError(policy, cacheKey, req.URI, err)
 	}
-
-	resp, err = resolver.Get(ctx, req)
 	if err != nil {
 		var extraMessage string
 		_, canSetupViaAuthenticator := authenticator.(api.URISetupper)
 		_, canSetupViaResolver := resolver.(api.URISetupper)
-		if canSetupViaAuthenticator || canSetupViaResolver {
+		// setup instructions are interactive and don't help in CI
+		if (canSetupViaAuthenticator || canSetupViaResolver) && !ci.Active() {
 			extraMessage = fmt.Sprintf("\n\nTip: try running the following command for setup instructions:\n  $ %s setup-uri %s", os.Args[0], req.URI)
 		}
 		logging.Fatalf("%s%s", err, extraMessage)
 	}
 
-	err = json.NewEncoder(os.Stdout).Encode(resp)
+	writeResponse(ctx, cache, cacheKey, resp)
+}
+
+// errorPolicy returns the on_error policy of the url config, defaulting to "fail".
+func errorPolicy(rule *config.URLConfig) (string, error) {
+	if rule == nil || len(rule.OnError) == 0 {
+		return config.OnErrorFail, nil
+	}
+	switch rule.OnError {
+	case config.OnErrorFail, config.OnErrorAnonymous, config.OnErrorWarnOnce:
+		return rule.OnError, nil
+	}
+	return "", fmt.Errorf(`invalid configuration file: unknown on_error %q. Possible values are "fail", "anonymous" and "warn_once"`, rule.OnError)
+}
+
+// onError applies the on_error policy to an error of the helper.
+// It returns the response to send instead, or the error if the request must fail.
+func onError(policy, cacheKey, uri string, err error) (api.GetCredentialsResponse, error) {
+	switch policy {
+	case config.OnErrorAnonymous:
+		logging.Errorf("%s\ncontinuing without credentials (on_error: %s)", err, policy)
+		return api.GetCredentialsResponse{}, nil
+	case config.OnErrorWarnOnce:
+		warnOnce(cacheKey, "credential helper failed for %s - continuing without credentials (on_error: %s): %s", uri, policy, err)
+		// cache the negative result to avoid retrying the helper on every request
+		return api.GetCredentialsResponse{
+			Expires: time.Now().Add(negativeCacheTTL).UTC().Format(time.RFC3339),
+		}, nil
+	}
+	return api.GetCredentialsResponse{}, err
+}
+
+// writeResponse prints the response to stdout and sends it to the agent for caching.
+func writeResponse(ctx context.Context, cache api.Cache, cacheKey string, resp api.GetCredentialsResponse) {
+	err := json.NewEncoder(os.Stdout).Encode(resp)
 	if err != nil {
 		logging.Fatalf("printing response to stdout: %s", err)
 	}
@@ -146,6 +221,36 @@ func foreground(ctx context.Context, cache api.Cache, helperFactory api.HelperFa
 	}
 }
 
+// warnOnce writes a warning to the syslog,
+// unless a warning for the same cache key was written within the last warnInterval.
+// A stamp file in the run directory remembers when the last warning was written,
+// so the rate limit also applies across processes.
+// It returns true if the warning was written.
+func warnOnce(cacheKey string, format string, args ...any) bool {
+	stampPath := warnStampPath(cacheKey)
+	if info, err := os.Stat(stampPath); err == nil && time.Since(info.ModTime()) < warnInterval {
+		logging.Debugf(format, args...)
+		return false
+	}
+	logging.SyslogWarnf(format, args...)
+	if err := os.MkdirAll(locate.Run(), 0o700); err != nil {
+		logging.Debugf("creating run directory: %v", err)
+		return true
+	}
+	if err := os.WriteFile(stampPath, nil, 0o600); err != nil {
+		logging.Debugf("writing warning stamp file: %v", err)
+		return true
+	}
+	now := time.Now()
+	_ = os.Chtimes(stampPath, now, now)
+	return true
+}
+
+// warnStampPath returns the path of the stamp file of warnOnce for the cache key.
+func warnStampPath(cacheKey string) string {
+	return filepath.Join(locate.Run(), fmt.Sprintf("warned-%x", sha256.Sum256([]byte(cacheKey))))
+}
+
 func launchOrConnectAgent() (api.Cache, func() error, error) {
 	if shouldRunStandalone() {
 		logging.Debugf("running in standalone mode")
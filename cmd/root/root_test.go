@@ -0,0 +1,92 @@
+package root
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tweag/credential-helper/api"
+	"github.com/tweag/credential-helper/config"
+	"github.com/tweag/credential-helper/logging"
+)
+
+func TestErrorPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    *config.URLConfig
+		want    string
+		wantErr string
+	}{
+		{name: "no url config", want: config.OnErrorFail},
+		{name: "default", rule: &config.URLConfig{}, want: config.OnErrorFail},
+		{name: "fail", rule: &config.URLConfig{OnError: "fail"}, want: config.OnErrorFail},
+		{name: "anonymous", rule: &config.URLConfig{OnError: "anonymous"}, want: config.OnErrorAnonymous},
+		{name: "warn_once", rule: &config.URLConfig{OnError: "warn_once"}, want: config.OnErrorWarnOnce},
+		{name: "invalid", rule: &config.URLConfig{OnError: "ignore"}, wantErr: `unknown on_error "ignore"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := errorPolicy(tt.rule)
+			if len(tt.wantErr) > 0 {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, policy)
+		})
+	}
+}
+
+func TestOnError(t *testing.T) {
+	logging.DisableSyslog()
+	t.Setenv(api.WorkdirEnv, t.TempDir())
+	helperErr := errors.New("token expired")
+
+	resp, err := onError(config.OnErrorFail, "key", "https://example.com/file", helperErr)
+	assert.ErrorIs(t, err, helperErr)
+	assert.Equal(t, api.GetCredentialsResponse{}, resp)
+
+	// anonymous responses are not cached
+	resp, err = onError(config.OnErrorAnonymous, "key", "https://example.com/file", helperErr)
+	assert.NoError(t, err)
+	assert.Equal(t, api.GetCredentialsResponse{}, resp)
+
+	// warn_once responses are cached for a short time
+	resp, err = onError(config.OnErrorWarnOnce, "key", "https://example.com/file", helperErr)
+	assert.NoError(t, err)
+	assert.Empty(t, resp.Headers)
+	expires, err := time.Parse(time.RFC3339, resp.Expires)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(negativeCacheTTL), expires, time.Minute)
+}
+
+func TestWarnOnce(t *testing.T) {
+	logging.DisableSyslog()
+	workdir := t.TempDir()
+	t.Setenv(api.WorkdirEnv, workdir)
+
+	// one warning per cache key
+	assert.True(t, warnOnce("first", "helper failed"))
+	assert.False(t, warnOnce("first", "helper failed"))
+	assert.True(t, warnOnce("second", "helper failed"))
+	assert.False(t, warnOnce("second", "helper failed"))
+
+	// the stamp files are kept in the run directory of the workdir
+	stamp := warnStampPath("first")
+	assert.Equal(t, filepath.Join(workdir, "run"), filepath.Dir(stamp))
+	_, err := os.Stat(stamp)
+	assert.NoError(t, err)
+
+	// the warning is repeated after warnInterval
+	old := time.Now().Add(-warnInterval - time.Minute)
+	assert.NoError(t, os.Chtimes(stamp, old, old))
+	assert.True(t, warnOnce("first", "helper failed"))
+	assert.False(t, warnOnce("first", "helper failed"))
+
+	// stamps of another workdir are independent
+	t.Setenv(api.WorkdirEnv, t.TempDir())
+	assert.True(t, warnOnce("first", "helper failed"))
+}
@@ -0,0 +1,243 @@
+package setup
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/tweag/credential-helper/agent/locate"
+	"github.com/tweag/credential-helper/secretstore"
+)
+
+const bundleUsage = `Creates and edits encrypted bundles of secrets that can be committed to the workspace.
+Bundles are age files (https://age-encryption.org) encrypted for X25519 recipients.
+
+Usage: credential-helper bundle [--bundle file] [COMMAND] [ARGS...]
+
+Commands:
+  keygen                        creates an identity, stores it in the keyring (or --output file) and prints its recipient
+  recipient                     prints the recipient of your identity
+  init [recipient...]           creates an empty bundle for your identity and the given recipients
+  set [--file file] [key]       adds or rotates an entry using a secret read from a file or stdin
+  remove [key]                  removes an entry
+  list                          lists the entries and recipients
+  add-recipient [recipient]     gives a recipient (age1...) access to all entries
+  remove-recipient [recipient]  revokes access of a recipient and re-encrypts all entries with a new key
+
+The identity (AGE-SECRET-KEY-1...) is read from $CREDENTIAL_HELPER_BUNDLE_IDENTITY, the file tweag-credential-helper/bundle-identity
+in the user config directory, or the keyring. Identities created by age-keygen can be used as well.
+
+Examples:
+  $ credential-helper bundle keygen
+  $ credential-helper bundle init
+  $ credential-helper bundle set artifacts-token < token.txt
+  $ credential-helper bundle add-recipient age1...`
+
+// BundleProcess is the entry point for the bundle command.
+func BundleProcess(args []string) {
+	var bundlePath string
+
+	flagSet := flag.NewFlagSet("bundle", flag.ExitOnError)
+	flagSet.Usage = func() {
+		fmt.Fprintln(flagSet.Output(), bundleUsage)
+		fmt.Fprintln(flagSet.Output())
+		flagSet.PrintDefaults()
+		os.Exit(1)
+	}
+	flagSet.StringVar(&bundlePath, "bundle", secretstore.DefaultBundlePath, "Path of the bundle")
+
+	if err := flagSet.Parse(args); err != nil {
+		fatalFmt("parsing flags for bundle: %v", err)
+	}
+	if flagSet.NArg() < 1 {
+		flagSet.Usage()
+	}
+	// the credential-helper process changes it's own working directory
+	// during setup.
+	// This remapping is necessary to find original, relative paths.
+	bundlePath = locate.RemapToOriginalWorkingDirectory(locate.ExpandPath(bundlePath))
+
+	command, commandArgs := flagSet.Arg(0), flagSet.Args()[1:]
+	switch command {
+	case "keygen":
+		bundleKeygen(commandArgs)
+	case "recipient":
+		fmt.Println(findIdentity().Recipient())
+	case "init":
+		bundleInit(bundlePath, commandArgs)
+	case "set":
+		bundleSet(bundlePath, commandArgs)
+	case "remove":
+		requireArgs("remove", commandArgs, 1)
+		bundle := readBundle(bundlePath)
+		if err := bundle.Delete(commandArgs[0]); err != nil {
+			fatalFmt("removing entry %s: %v", commandArgs[0], err)
+		}
+		writeBundle(bundle, bundlePath)
+		fmt.Printf("Removed entry %s\n", commandArgs[0])
+	case "list":
+		bundleList(bundlePath)
+	case "add-recipient":
+		requireArgs("add-recipient", commandArgs, 1)
+		bundle := readBundle(bundlePath)
+		if err := bundle.AddRecipient(commandArgs[0]); err != nil {
+			fatalFmt("adding recipient: %v", err)
+		}
+		writeBundle(bundle, bundlePath)
+		fmt.Printf("Added recipient %s\n", commandArgs[0])
+	case "remove-recipient":
+		requireArgs("remove-recipient", commandArgs, 1)
+		bundle := readBundle(bundlePath)
+		if err := bundle.RemoveRecipient(commandArgs[0]); err != nil {
+			fatalFmt("removing recipient: %v", err)
+		}
+		writeBundle(bundle, bundlePath)
+		fmt.Printf("Removed recipient %s. The entries are re-encrypted, but the removed recipient may have copied them: rotate the secrets to revoke access.\n", commandArgs[0])
+	default:
+		flagSet.Usage()
+	}
+}
+
+func bundleKeygen(args []string) {
+	var output string
+	flagSet := flag.NewFlagSet("bundle keygen", flag.ExitOnError)
+	flagSet.StringVar(&output, "output", "", "Write the identity to this file instead of the keyring")
+	if err := flagSet.Parse(args); err != nil {
+		fatalFmt("parsing flags for bundle keygen: %v", err)
+	}
+	if existing, err := secretstore.DefaultIdentitySources().Find(); err == nil && len(output) == 0 {
+		fatalFmt("an identity already exists. Its recipient is:\n%s", existing.Recipient())
+	}
+	identity, err := secretstore.GenerateIdentity()
+	if err != nil {
+		fatalFmt("generating identity: %v", err)
+	}
+	if len(output) > 0 {
+		output = locate.RemapToOriginalWorkingDirectory(output)
+		if err := os.WriteFile(output, []byte(identity.String()+"\n"), 0o600); err != nil {
+			fatalFmt("writing identity: %v", err)
+		}
+		fmt.Fprintf(os.Stderr, "Wrote identity to %s\n", output)
+	} else {
+		store, err := secretstore.Open(secretstore.StoreAuto)
+		if err != nil {
+			fatalFmt("%v", err)
+		}
+		if err := store.Set(secretstore.BundleIdentityService, identity.String()); err != nil {
+			fatalFmt("storing identity in %s: %v", store.Name(), err)
+		}
+		fmt.Fprintf(os.Stderr, "Stored identity in %s\n", store.Name())
+	}
+	fmt.Println(identity.Recipient())
+}
+
+func bundleInit(bundlePath string, recipients []string) {
+	if _, err := os.Stat(bundlePath); err == nil {
+		fatalFmt("bundle %s already exists", bundlePath)
+	}
+	own := findIdentity().Recipient()
+	if !slices.Contains(recipients, own) {
+		recipients = append([]string{own}, recipients...)
+	}
+	bundle, err := secretstore.NewBundle(recipients)
+	if err != nil {
+		fatalFmt("creating bundle: %v", err)
+	}
+	writeBundle(bundle, bundlePath)
+	fmt.Printf("Created bundle %s\n", bundlePath)
+}
+
+func bundleSet(bundlePath string, args []string) {
+	var sourceFilePath string
+	flagSet := flag.NewFlagSet("bundle set", flag.ExitOnError)
+	flagSet.StringVar(&sourceFilePath, "file", "", "File to read the secret from")
+	if err := flagSet.Parse(args); err != nil {
+		fatalFmt("parsing flags for bundle set: %v", err)
+	}
+	requireArgs("set", flagSet.Args(), 1)
+	key := flagSet.Arg(0)
+
+	var source io.Reader = os.Stdin
+	if len(sourceFilePath) > 0 {
+		file, err := os.Open(locate.RemapToOriginalWorkingDirectory(sourceFilePath))
+		if err != nil {
+			fatalFmt("opening source file %s: %v", sourceFilePath, err)
+		}
+		defer file.Close()
+		source = file
+	} else {
+		fmt.Fprintf(os.Stderr, "Reading secret from stdin.\n")
+	}
+	secret, err := io.ReadAll(source)
+	if err != nil {
+		fatalFmt("reading secret: %v", err)
+	}
+	// files and shells (echo, heredocs) end the secret with a newline, which is not part of it
+	secret = bytes.TrimSuffix(secret, []byte("\n"))
+	secret = bytes.TrimSuffix(secret, []byte("\r"))
+
+	bundle := readBundle(bundlePath)
+	_, exists := bundle.Entries[key]
+	bundle.Set(key, string(secret))
+	writeBundle(bundle, bundlePath)
+	if exists {
+		fmt.Printf("Rotated entry %s\n", key)
+	} else {
+		fmt.Printf("Added entry %s\n", key)
+	}
+}
+
+func bundleList(bundlePath string) {
+	bundle := readBundle(bundlePath)
+	keys := make([]string, 0, len(bundle.Entries))
+	for key := range bundle.Entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	fmt.Printf("Entries:\n")
+	for _, key := range keys {
+		fmt.Printf("  %s\n", key)
+	}
+	fmt.Printf("Recipients:\n  %s\n", strings.Join(bundle.Recipients, "\n  "))
+}
+
+func findIdentity() *secretstore.Identity {
+	identity, err := secretstore.DefaultIdentitySources().Find()
+	if errors.Is(err, secretstore.ErrNotFound) {
+		fatalFmt("no bundle identity found. Create one using:\n  $ %s bundle keygen", filepath.Base(os.Args[0]))
+	}
+	if err != nil {
+		fatalFmt("reading bundle identity: %v", err)
+	}
+	return identity
+}
+
+func readBundle(path string) *secretstore.Bundle {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		fatalFmt("bundle %s does not exist. Create it using:\n  $ %s bundle init", path, filepath.Base(os.Args[0]))
+	}
+	bundle, err := secretstore.ReadBundle(path, findIdentity())
+	if err != nil {
+		fatalFmt("reading bundle: %v", err)
+	}
+	return bundle
+}
+
+func writeBundle(bundle *secretstore.Bundle, path string) {
+	if err := bundle.WriteFile(path); err != nil {
+		fatalFmt("writing bundle %s: %v", path, err)
+	}
+}
+
+func requireArgs(command string, args []string, n int) {
+	if len(args) != n {
+		fatalFmt("bundle %s expects %d argument(s), got %d", command, n, len(args))
+	}
+}
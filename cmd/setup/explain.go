@@ -0,0 +1,71 @@
+package setup
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/tweag/credential-helper/api"
+	"github.com/tweag/credential-helper/cmd/internal/util"
+	"github.com/tweag/credential-helper/config"
+)
+
+// ExplainProcess is the entry point for the explain command.
+// It prints how the helper for a uri is chosen, without looking up any credentials.
+func ExplainProcess(args []string, helperFactory api.HelperFactory, configReader config.ConfigReader) {
+	ctx := context.Background()
+
+	flagSet := flag.NewFlagSet("explain", flag.ExitOnError)
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Explains which profile, url config and helper are used for a given uri.\n\n")
+		fmt.Fprintf(flagSet.Output(), "Usage: credential-helper explain [uri]\n")
+		flagSet.PrintDefaults()
+		fmt.Fprintf(flagSet.Output(), "\nExamples:\n")
+		fmt.Fprintf(flagSet.Output(), "  $ credential-helper explain https://github.com/my-org/project/releases/download/v1.2.3/my-artifact.tar.gz\n")
+		os.Exit(1)
+	}
+
+	if err := flagSet.Parse(args); err != nil {
+		fatalFmt("parsing flags for explain: %v", err)
+	}
+
+	if flagSet.NArg() != 1 {
+		flagSet.Usage()
+	}
+
+	uri := flagSet.Arg(0)
+
+	ctx, authenticator, selection := util.Configure(ctx, helperFactory, configReader, uri)
+	profile := selection.Profile
+	if len(profile) == 0 {
+		profile = "(none)"
+	}
+	fmt.Printf("Profile:   %s\n", profile)
+	fmt.Printf("URI:       %s\n", uri)
+	if selection.URI != uri {
+		fmt.Printf("Upstream:  %s (rewritten by the downloader config)\n", selection.URI)
+	}
+	if selection.Rule != nil {
+		matcher, err := json.Marshal(selection.Rule.URLMatcher)
+		if err != nil {
+			fatalFmt("encoding url config: %v", err)
+		}
+		onError := selection.Rule.OnError
+		if len(onError) == 0 {
+			onError = config.OnErrorFail
+		}
+		fmt.Printf("Rule:      %s\n", matcher)
+		fmt.Printf("Helper:    %s\n", selection.Rule.Helper)
+		fmt.Printf("On error:  %s\n", onError)
+	} else {
+		fmt.Printf("Rule:      (none)\n")
+		fmt.Printf("Helper:    %T\n", authenticator)
+	}
+	cacheKey := util.CacheKey(ctx, authenticator, selection, api.GetCredentialsRequest{URI: selection.URI})
+	if len(cacheKey) == 0 {
+		cacheKey = "(not cached)"
+	}
+	fmt.Printf("Cache key: %s\n", cacheKey)
+}
@@ -8,7 +8,7 @@ import (
 	"strings"
 
 	"github.com/tweag/credential-helper/agent/locate"
-	keyring "github.com/zalando/go-keyring"
+	"github.com/tweag/credential-helper/secretstore"
 )
 
 // KeyringProcess is the entry point for the setup-keyring command.
@@ -17,17 +17,20 @@ func KeyringProcess(args []string) {
 	var sourceFilePath string
 	var read bool
 	var clear bool
+	var storeName string
 
 	flagSet := flag.NewFlagSet("setup-keyring", flag.ExitOnError)
 	flagSet.Usage = func() {
-		fmt.Fprintf(flagSet.Output(), "Stores a secret read from a file or stdin in the system keyring under the name specified by service.\n\n")
-		fmt.Fprintf(flagSet.Output(), "Usage: credential-helper setup-keyring [--file file] [--read | --clear] [service]\n")
+		fmt.Fprintf(flagSet.Output(), "Stores a secret read from a file or stdin in the system keyring under the name specified by service.\n")
+		fmt.Fprintf(flagSet.Output(), "Use --store auto to store the secret in an encrypted file if no system keyring is available (like on headless Linux machines).\n\n")
+		fmt.Fprintf(flagSet.Output(), "Usage: credential-helper setup-keyring [--file file] [--store auto|system|file] [--read | --clear] [service]\n")
 		flagSet.PrintDefaults()
 		examples := []string{
 			"credential-helper setup-keyring gh:github.com < secret.txt",
 			"credential-helper setup-keyring --clear gh:github.com",
 			"credential-helper setup-keyring --file secret.txt tweag-credential-helper:remoteapis",
 			"credential-helper setup-keyring --read tweag-credential-helper:buildbuddy_api_key",
+			"credential-helper setup-keyring --store file gh:github.com < secret.txt",
 		}
 		fmt.Fprintf(flagSet.Output(), "\nExamples:\n")
 		for _, example := range examples {
@@ -38,6 +41,7 @@ func KeyringProcess(args []string) {
 	flagSet.StringVar(&sourceFilePath, "file", "", "File to read the secret from")
 	flagSet.BoolVar(&read, "read", false, "Print the current secret stored in the keyring for this service to stdout and exit")
 	flagSet.BoolVar(&clear, "clear", false, "Clear the secret stored in the keyring for this service and exit")
+	flagSet.StringVar(&storeName, "store", secretstore.StoreSystem, `Where to store the secret: "system" (system keyring), "file" (encrypted file) or "auto" (system keyring if available, encrypted file otherwise)`)
 
 	if err := flagSet.Parse(args); err != nil {
 		fatalFmt("parsing flags for setup-keyring: %v", err)
@@ -52,17 +56,21 @@ func KeyringProcess(args []string) {
 	if read && clear {
 		fatalFmt("cannot specify both --read and --clear")
 	}
+	store, err := secretstore.Open(storeName)
+	if err != nil {
+		fatalFmt("%v", err)
+	}
 	if read {
-		secret, err := keyring.Get(service, "")
+		secret, err := store.Get(service)
 		if err != nil {
-			fatalFmt("reading secret from keyring: %v", err)
+			fatalFmt("reading secret from %s: %v", store.Name(), err)
 		}
 		fmt.Print(secret)
 		return
 	}
 	if clear {
-		if err := keyring.Delete(service, ""); err != nil {
-			fatalFmt("deleting secret from keyring: %v", err)
+		if err := store.Delete(service); err != nil {
+			fatalFmt("deleting secret from %s: %v", store.Name(), err)
 		}
 		fmt.Printf("Cleared secret %s\n", service)
 		return
@@ -94,11 +102,11 @@ func KeyringProcess(args []string) {
 		fatalFmt("reading secret from %s: %v", sourceName, err)
 	}
 
-	if err := keyring.Set(service, "", string(secret)); err != nil {
-		fatalFmt("storing secret in keyring: %v", err)
+	if err := store.Set(service, string(secret)); err != nil {
+		fatalFmt("storing secret in %s: %v", store.Name(), err)
 	}
 
-	fmt.Printf("Stored secret %s\n", service)
+	fmt.Printf("Stored secret %s in %s\n", service, store.Name())
 }
 
 func fatalFmt(format string, args ...any) {
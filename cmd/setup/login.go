@@ -0,0 +1,50 @@
+package setup
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/tweag/credential-helper/api"
+	"github.com/tweag/credential-helper/authenticate/login"
+	"github.com/tweag/credential-helper/cmd/internal/util"
+	"github.com/tweag/credential-helper/config"
+)
+
+// LoginProcess is the entry point for the login command.
+// It logs in to the oauth2 sources of the lookup chain configured for the uri.
+func LoginProcess(args []string, helperFactory api.HelperFactory, configReader config.ConfigReader) {
+	ctx := context.Background()
+
+	flagSet := flag.NewFlagSet("login", flag.ExitOnError)
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Logs in to the OAuth 2.0 issuer configured for a given uri using the device flow and stores the refresh token in the keyring.\n\n")
+		fmt.Fprintf(flagSet.Output(), "Usage: credential-helper login [uri]\n")
+		flagSet.PrintDefaults()
+		fmt.Fprintf(flagSet.Output(), "\nExamples:\n")
+		fmt.Fprintf(flagSet.Output(), "  $ credential-helper login https://artifacts.acme.corp/releases/v1.2.3/my-artifact.tar.gz\n")
+		os.Exit(1)
+	}
+
+	if err := flagSet.Parse(args); err != nil {
+		fatalFmt("parsing flags for login: %v", err)
+	}
+
+	if flagSet.NArg() != 1 {
+		flagSet.Usage()
+	}
+
+	uri := flagSet.Arg(0)
+
+	ctx, _, selection := util.Configure(ctx, helperFactory, configReader, uri)
+	if len(selection.Profile) > 0 {
+		fmt.Printf("Using profile %s.\n\n", selection.Profile)
+	}
+	if selection.URI != uri {
+		fmt.Printf("%s is rewritten from the upstream url %s. Logging in for the upstream url.\n\n", uri, selection.URI)
+	}
+	if err := login.Login(ctx, os.Stdout); err != nil {
+		fatalFmt("logging in for %s: %v", selection.URI, err)
+	}
+}
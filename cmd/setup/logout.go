@@ -0,0 +1,50 @@
+package setup
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/tweag/credential-helper/api"
+	"github.com/tweag/credential-helper/authenticate/login"
+	"github.com/tweag/credential-helper/cmd/internal/util"
+	"github.com/tweag/credential-helper/config"
+)
+
+// LogoutProcess is the entry point for the logout command.
+// It makes the oauth2 and git-credential sources of the lookup chain configured for the uri forget their credentials.
+func LogoutProcess(args []string, helperFactory api.HelperFactory, configReader config.ConfigReader) {
+	ctx := context.Background()
+
+	flagSet := flag.NewFlagSet("logout", flag.ExitOnError)
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Forgets the credentials for a given uri: deletes OAuth 2.0 refresh tokens and rejects git credentials (git credential reject).\n\n")
+		fmt.Fprintf(flagSet.Output(), "Usage: credential-helper logout [uri]\n")
+		flagSet.PrintDefaults()
+		fmt.Fprintf(flagSet.Output(), "\nExamples:\n")
+		fmt.Fprintf(flagSet.Output(), "  $ credential-helper logout https://artifacts.acme.corp/releases/v1.2.3/my-artifact.tar.gz\n")
+		os.Exit(1)
+	}
+
+	if err := flagSet.Parse(args); err != nil {
+		fatalFmt("parsing flags for logout: %v", err)
+	}
+
+	if flagSet.NArg() != 1 {
+		flagSet.Usage()
+	}
+
+	uri := flagSet.Arg(0)
+
+	ctx, _, selection := util.Configure(ctx, helperFactory, configReader, uri)
+	if len(selection.Profile) > 0 {
+		fmt.Printf("Using profile %s.\n\n", selection.Profile)
+	}
+	if selection.URI != uri {
+		fmt.Printf("%s is rewritten from the upstream url %s. Logging out for the upstream url.\n\n", uri, selection.URI)
+	}
+	if err := login.Logout(ctx, os.Stdout); err != nil {
+		fatalFmt("logging out for %s: %v", selection.URI, err)
+	}
+}
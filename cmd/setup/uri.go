@@ -46,7 +46,14 @@ func URIProcess(args []string, helperFactory api.HelperFactory, configReader con
 
 	uri := flagSet.Arg(0)
 
-	ctx, authenticator := util.Configure(ctx, helperFactory, configReader, uri)
+	ctx, authenticator, selection := util.Configure(ctx, helperFactory, configReader, uri)
+	if len(selection.Profile) > 0 {
+		fmt.Printf("Using profile %s.\n\n", selection.Profile)
+	}
+	if selection.URI != uri {
+		fmt.Printf("%s is rewritten from the upstream url %s. Using the credentials of the upstream url.\n\n", uri, selection.URI)
+		uri = selection.URI
+	}
 
 	var instructionGiver api.URISetupper
 
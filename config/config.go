@@ -1,12 +1,17 @@
 package config
 
 import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 
 	"github.com/tweag/credential-helper/agent/locate"
 	"github.com/tweag/credential-helper/api"
@@ -17,52 +22,176 @@ import (
 var ErrConfigNotFound = errors.New("config file not found")
 
 type URLConfig struct {
-	Scheme string          `json:"scheme,omitempty"`
-	Host   string          `json:"host,omitempty"`
-	Path   string          `json:"path,omitempty"`
-	Helper string          `json:"helper"`
-	Config json.RawMessage `json:"config,omitempty"` // the schema of this field is defined by the helper
+	URLMatcher
+	// Exclude lists matchers that veto this rule.
+	// If any of them matches the url, the rule is skipped.
+	Exclude []URLMatcher `json:"exclude,omitempty"`
+	// Priority orders rules. Rules with a higher priority are tried first.
+	// Rules with the same priority are tried in the order of the config file.
+	Priority int             `json:"priority,omitempty"`
+	Helper   string          `json:"helper"`
+	Config   json.RawMessage `json:"config,omitempty"` // the schema of this field is defined by the helper
+	// Headers are applied in order to the response of the helper before it is cached.
+	Headers []HeaderRule `json:"headers,omitempty"`
+	// OnError decides what happens if the helper fails: "fail" (default), "anonymous" or "warn_once".
+	OnError string `json:"on_error,omitempty"`
+}
+
+// Values of URLConfig.OnError
+const (
+	// OnErrorFail exits with an error, which aborts the request in Bazel.
+	OnErrorFail = "fail"
+	// OnErrorAnonymous logs the error and returns an empty response, which is not cached.
+	OnErrorAnonymous = "anonymous"
+	// OnErrorWarnOnce writes a rate-limited warning to the syslog and returns an empty response, which is cached for a short time.
+	OnErrorWarnOnce = "warn_once"
+)
+
+// Values of Config.CIPreset
+const (
+	// CIPresetAuto applies the CI preset if a CI provider is detected.
+	CIPresetAuto = "auto"
+	// CIPresetOff never applies the CI preset.
+	CIPresetOff = "off"
+)
+
+// Actions of a HeaderRule.
+const (
+	HeaderActionAdd    = "add"
+	HeaderActionSet    = "set"
+	HeaderActionRemove = "remove"
+	HeaderActionRename = "rename"
+)
+
+// HeaderRule describes a transformation of the headers of a response.
+// Header names are compared case-insensitively.
+type HeaderRule struct {
+	// Action is one of "add", "set", "remove" or "rename".
+	Action string `json:"action"`
+	// Name is the name of the header to transform.
+	Name string `json:"name"`
+	// Value is the value for "add" and "set".
+	// It may reference lookup chain bindings of the helper config, like "Bearer {{default}}".
+	Value string `json:"value,omitempty"`
+	// To is the new name of the header for "rename".
+	To string `json:"to,omitempty"`
 }
 
 type Config struct {
 	URLs []URLConfig `json:"urls,omitempty"`
+	// Profiles are named sets of url configs. See ActiveProfile for how a profile is selected.
+	Profiles map[string]Profile `json:"profiles,omitempty"`
+	// DefaultProfile is the profile used when no profile is selected explicitly.
+	DefaultProfile string `json:"default_profile,omitempty"`
+	// CIPreset controls the defaults applied when running in a CI environment: "auto" (default) or "off".
+	CIPreset string `json:"ci_preset,omitempty"`
+	// Rewrite describes url rewrites performed by Bazel's downloader.
+	Rewrite *RewriteConfig `json:"rewrite,omitempty"`
+
+	// profile is the name of the profile merged by WithProfile.
+	profile string
+	// profileURLs is the number of url configs at the start of URLs that come from the profile.
+	profileURLs int
 }
 
+// FindHelper returns the helper and the helper config for the given uri.
 func (c Config) FindHelper(uri string) (api.Helper, []byte, error) {
-	requested, err := url.Parse(uri)
+	rule, err := c.FindRule(uri)
+	if err != nil {
+		return nil, nil, err
+	}
+	helper, err := HelperFor(rule)
 	if err != nil {
 		return nil, nil, err
 	}
+	if rule == nil {
+		return helper, nil, nil
+	}
+	return helper, rule.Config, nil
+}
+
+// HelperFor returns the registered helper named by the url config.
+// A nil url config (no match) selects the null helper.
+func HelperFor(rule *URLConfig) (api.Helper, error) {
+	if rule == nil {
+		// this is equivalent to null.Null{}
+		// but avoids the import of the null package
+		return registry.HelperFromString("null"), nil
+	}
+	helper := registry.HelperFromString(rule.Helper)
+	if helper == nil {
+		return nil, fmt.Errorf("unknown helper: %s", rule.Helper)
+	}
+	logging.Debugf("selected helper %s from config", rule.Helper)
+	return helper, nil
+}
+
+// FindRule returns the first url config (by priority) that matches the given uri.
+// The helper config of the returned url config is interpolated.
+// If no url config matches, FindRule returns nil.
+func (c Config) FindRule(uri string) (*URLConfig, error) {
+	requested, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
 	if len(c.URLs) == 0 {
-		return nil, nil, errors.New("invalid configuration file: no helpers configured")
+		return nil, errors.New("invalid configuration file: no helpers configured")
 	}
-	for _, urlConfig := range c.URLs {
+	for i, urlConfig := range c.URLs {
 		if len(urlConfig.Helper) == 0 {
-			return nil, nil, errors.New("invalid configuration file: helper field is required")
+			return nil, fmt.Errorf("invalid configuration file: %s: helper field is required", c.location(i))
 		}
-
-		// if a scheme is specified, it must match
-		if len(urlConfig.Scheme) > 0 && urlConfig.Scheme != requested.Scheme {
-			continue
-		}
-		// if a host is specified, it must glob match
-		if len(urlConfig.Host) > 0 && !globMatch(urlConfig.Host, requested.Host) {
-			continue
+	}
+	for _, i := range c.byPriority() {
+		urlConfig := c.URLs[i]
+		matches, err := urlConfig.Matches(requested)
+		if err != nil {
+			return nil, fmt.Errorf("invalid configuration file: %s: %w", c.location(i), err)
 		}
-		// if a path is specified, it must glob match
-		if len(urlConfig.Path) > 0 && !globMatch(urlConfig.Path, requested.Path) {
+		if !matches {
 			continue
 		}
-		helper := registry.HelperFromString(urlConfig.Helper)
-		if helper != nil {
-			logging.Debugf("selected helper %s from config", urlConfig.Helper)
-			return helper, urlConfig.Config, nil
+		urlConfig.Config, err = interpolate(urlConfig.Config)
+		if err != nil {
+			return nil, fmt.Errorf("invalid configuration file: %s.config%w", c.location(i), err)
 		}
-		return nil, nil, fmt.Errorf("unknown helper: %s", urlConfig.Helper)
+		return &urlConfig, nil
+	}
+	return nil, nil
+}
+
+// location returns the path of the url config with the given index in the config file,
+// like .urls[0] or .profiles.ci.urls[0] for url configs merged from a profile.
+func (c Config) location(i int) string {
+	if i < c.profileURLs {
+		return fmt.Sprintf(".profiles.%s.urls[%d]", c.profile, i)
+	}
+	return fmt.Sprintf(".urls[%d]", i-c.profileURLs)
+}
+
+// CacheNamespace returns a stable hash of the url config, including the helper config.
+// It is used to separate cache entries of different url configs
+// and to invalidate cached credentials when the configuration changes.
+func (u URLConfig) CacheNamespace() string {
+	raw, err := json.Marshal(u)
+	if err != nil {
+		return ""
 	}
-	// this is equivalent to null.Null{}
-	// but avoids the import of the null package
-	return registry.HelperFromString("null"), nil, nil
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:8])
+}
+
+// byPriority returns the indices of the url configs ordered by descending priority.
+// The sort is stable, so rules with equal priority keep their order from the config file.
+func (c Config) byPriority() []int {
+	indices := make([]int, len(c.URLs))
+	for i := range indices {
+		indices[i] = i
+	}
+	slices.SortStableFunc(indices, func(a, b int) int {
+		return cmp.Compare(c.URLs[b].Priority, c.URLs[a].Priority)
+	})
+	return indices
 }
 
 type ConfigReader interface {
@@ -89,10 +218,32 @@ func (r OSReader) Read() (Config, error) {
 	if err != nil {
 		return Config{}, err
 	}
+	if err := config.compile(); err != nil {
+		return Config{}, err
+	}
 
 	return config, nil
 }
 
+// compile validates the url matchers of the config and its profiles
+// and caches their compiled regular expressions.
+func (c *Config) compile() error {
+	for i := range c.URLs {
+		if err := c.URLs[i].compile(); err != nil {
+			return fmt.Errorf("invalid configuration file: .urls[%d]: %w", i, err)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.Profiles)) {
+		profile := c.Profiles[name]
+		for i := range profile.URLs {
+			if err := profile.URLs[i].compile(); err != nil {
+				return fmt.Errorf("invalid configuration file: .profiles.%s.urls[%d]: %w", name, i, err)
+			}
+		}
+	}
+	return nil
+}
+
 func globMatch(pattern, candidate string) bool {
 	patternIDX := 0
 	candidateIDX := 0
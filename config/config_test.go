@@ -0,0 +1,259 @@
+package config
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tweag/credential-helper/api"
+	"github.com/tweag/credential-helper/registry"
+)
+
+func TestURLMatcher(t *testing.T) {
+	testCases := []struct {
+		name    string
+		matcher URLMatcher
+		uri     string
+		want    bool
+	}{
+		{"empty matcher", URLMatcher{}, "https://example.com/foo", true},
+		{"scheme", URLMatcher{Scheme: "https"}, "http://example.com/foo", false},
+		{"host without port", URLMatcher{Host: "example.com"}, "https://example.com:8443/foo", false},
+		{"host with port field", URLMatcher{Host: "example.com", Port: "8443"}, "https://example.com:8443/foo", true},
+		{"host with other port field", URLMatcher{Host: "example.com", Port: "443"}, "https://example.com:8443/foo", false},
+		{"host with port", URLMatcher{Host: "example.com:8443"}, "https://example.com:443/foo", false},
+		{"default https port", URLMatcher{Port: "443"}, "https://example.com/foo", true},
+		{"explicit port", URLMatcher{Port: "443"}, "https://example.com:8443/foo", false},
+		{"port glob", URLMatcher{Port: "84*"}, "https://example.com:8443/foo", true},
+		{"legacy star crosses segments", URLMatcher{Path: "/tweag/*"}, "https://github.com/tweag/a/b", true},
+		{"single star within segment", URLMatcher{Path: "/tweag/*/releases/**"}, "https://github.com/tweag/a/b/releases/x", false},
+		{"double star", URLMatcher{Path: "/tweag/*/releases/**"}, "https://github.com/tweag/a/releases/download/v1/x.tar.gz", true},
+		{"double star matches nothing", URLMatcher{Path: "/a/**/b"}, "https://example.com/a/b", true},
+		{"trailing double star", URLMatcher{Path: "/a/**"}, "https://example.com/a", true},
+		{"query", URLMatcher{Query: map[string]string{"X-Amz-Expires": "*"}}, "https://example.com/?X-Amz-Expires=300", true},
+		{"missing query", URLMatcher{Query: map[string]string{"X-Amz-Expires": "*"}}, "https://example.com/", false},
+		{"host regex", URLMatcher{HostRegex: `[a-z]+\.example\.com`}, "https://cdn.example.com/", true},
+		{"host regex is anchored", URLMatcher{HostRegex: `example\.com`}, "https://cdn.example.com/", false},
+		{"path regex", URLMatcher{PathRegex: `/v2/.+/blobs/sha256:[0-9a-f]+`}, "https://ghcr.io/v2/org/img/blobs/sha256:abc123", true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			u, err := url.Parse(tc.uri)
+			assert.NoError(t, err)
+			got, err := tc.matcher.Matches(u)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestURLMatcherInvalidRegex(t *testing.T) {
+	u, _ := url.Parse("https://example.com/")
+	_, err := URLMatcher{PathRegex: "("}.Matches(u)
+	assert.Error(t, err)
+}
+
+func TestFindHelperOrder(t *testing.T) {
+	var cfg Config
+	err := json.Unmarshal([]byte(`{
+  "urls": [
+    {"host": "github.com", "helper": "null", "exclude": [{"path": "/tweag/**"}]},
+    {"host": "github.com", "helper": "github"},
+    {"host": "github.com", "path": "/tweag/public/**", "helper": "null", "priority": 10}
+  ]
+}`), &cfg)
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		uri  string
+		want string
+	}{
+		{"https://github.com/tweag/repo", "github"},
+		{"https://github.com/tweag/public/repo", "null"},
+		{"https://github.com/other/repo", "null"},
+		{"https://example.com/", "null"},
+	}
+	for _, tc := range testCases {
+		helper, _, err := cfg.FindHelper(tc.uri)
+		assert.NoError(t, err)
+		assert.Same(t, registry.HelperFromString(tc.want), helper, tc.uri)
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	t.Setenv("CREDENTIAL_HELPER_TEST_REGION", "eu-west-1")
+	t.Setenv("CREDENTIAL_HELPER_TEST_EMPTY", "")
+	t.Setenv(api.WorkspaceEnv, "/workspace")
+
+	testCases := []struct {
+		input string
+		want  string
+	}{
+		{"plain", "plain"},
+		{"${CREDENTIAL_HELPER_TEST_REGION}", "eu-west-1"},
+		{"region-${CREDENTIAL_HELPER_TEST_REGION}-1", "region-eu-west-1-1"},
+		{"${CREDENTIAL_HELPER_TEST_UNSET:-us-east-1}", "us-east-1"},
+		{"${CREDENTIAL_HELPER_TEST_EMPTY:-fallback}", "fallback"},
+		{"$${CREDENTIAL_HELPER_TEST_REGION}", "${CREDENTIAL_HELPER_TEST_REGION}"},
+		{"price: 5$", "price: 5$"},
+		{"%workspace%/secret.txt", filepath.Join("/workspace", "secret.txt")},
+		{"%workspace%suffix", "%workspace%suffix"},
+	}
+	for _, tc := range testCases {
+		got, err := interpolateString(tc.input)
+		assert.NoError(t, err, tc.input)
+		assert.Equal(t, tc.want, got, tc.input)
+	}
+
+	_, err := interpolateString("${CREDENTIAL_HELPER_TEST_UNSET}")
+	assert.ErrorContains(t, err, "CREDENTIAL_HELPER_TEST_UNSET is not set")
+	_, err = interpolateString("${CREDENTIAL_HELPER_TEST_REGION")
+	assert.ErrorContains(t, err, "unterminated")
+
+	raw, err := interpolate(json.RawMessage(`{"region": "${CREDENTIAL_HELPER_TEST_REGION}", "lookup_chain": [{"source": "static", "name": "${CREDENTIAL_HELPER_TEST_UNSET}"}]}`))
+	assert.Nil(t, raw)
+	assert.EqualError(t, err, ".lookup_chain[0].name: environment variable CREDENTIAL_HELPER_TEST_UNSET is not set (use ${CREDENTIAL_HELPER_TEST_UNSET:-default} to provide a default value)")
+}
+
+func TestCacheNamespace(t *testing.T) {
+	var cfg Config
+	err := json.Unmarshal([]byte(`{
+  "urls": [
+    {"host": "github.com", "path": "/org-a/*", "helper": "github", "config": {"lookup_chain": [{"source": "env", "name": "ORG_A_TOKEN"}]}},
+    {"host": "github.com", "path": "/org-b/*", "helper": "github", "config": {"lookup_chain": [{"source": "env", "name": "ORG_B_TOKEN"}]}}
+  ]
+}`), &cfg)
+	assert.NoError(t, err)
+
+	ruleA, err := cfg.FindRule("https://github.com/org-a/repo")
+	assert.NoError(t, err)
+	ruleB, err := cfg.FindRule("https://github.com/org-b/repo")
+	assert.NoError(t, err)
+	ruleAAgain, err := cfg.FindRule("https://github.com/org-a/other")
+	assert.NoError(t, err)
+
+	assert.NotEqual(t, ruleA.CacheNamespace(), ruleB.CacheNamespace())
+	assert.Equal(t, ruleA.CacheNamespace(), ruleAAgain.CacheNamespace())
+
+	rule, err := cfg.FindRule("https://example.com/")
+	assert.NoError(t, err)
+	assert.Nil(t, rule)
+}
+
+func TestRewriteResolveURI(t *testing.T) {
+	downloaderConfig := filepath.Join(t.TempDir(), "downloader.cfg")
+	err := os.WriteFile(downloaderConfig, []byte(`# mirror everything
+rewrite github.com/(.*) mirror.acme.corp/github/$1
+rewrite (storage.googleapis.com)/(.*) mirror.acme.corp/gcs/${1}/$2
+rewrite registry-1.docker.io/v2/(.*) mirror.acme.corp/dockerhub/v2/$1
+allow mirror.acme.corp
+block *
+`), 0o600)
+	assert.NoError(t, err)
+
+	// upstream credentials are the default
+	rewrite := &RewriteConfig{DownloaderConfig: downloaderConfig}
+
+	testCases := []struct {
+		uri         string
+		want        string
+		wantBlocked bool
+	}{
+		{"https://mirror.acme.corp/github/tweag/credential-helper/archive/v1.tar.gz", "https://github.com/tweag/credential-helper/archive/v1.tar.gz", false},
+		{"https://mirror.acme.corp/gcs/storage.googleapis.com/bucket/object", "https://storage.googleapis.com/bucket/object", false},
+		{"https://mirror.acme.corp/dockerhub/v2/library/hello-world/manifests/latest", "https://registry-1.docker.io/v2/library/hello-world/manifests/latest", false},
+		{"https://mirror.acme.corp/other/file", "https://mirror.acme.corp/other/file", false},
+		{"https://github.com/tweag/credential-helper", "https://github.com/tweag/credential-helper", true},
+	}
+	for _, tc := range testCases {
+		got, blocked, err := rewrite.ResolveURI(tc.uri)
+		assert.NoError(t, err, tc.uri)
+		assert.Equal(t, tc.want, got, tc.uri)
+		assert.Equal(t, tc.wantBlocked, blocked, tc.uri)
+	}
+
+	// the downloader config is only read once per process
+	assert.NoError(t, os.Remove(downloaderConfig))
+	got, _, err := rewrite.ResolveURI(testCases[0].uri)
+	assert.NoError(t, err)
+	assert.Equal(t, testCases[0].want, got)
+
+	rewrite.Credentials = RewriteCredentialsMirror
+	got, blocked, err := rewrite.ResolveURI(testCases[0].uri)
+	assert.NoError(t, err)
+	assert.False(t, blocked)
+	assert.Equal(t, testCases[0].uri, got)
+}
+
+func TestProfiles(t *testing.T) {
+	var cfg Config
+	err := json.Unmarshal([]byte(`{
+  "urls": [
+    {"host": "github.com", "helper": "github"}
+  ],
+  "profiles": {
+    "ci": {"urls": [{"host": "github.com", "path": "/tweag/**", "helper": "null"}]}
+  },
+  "default_profile": "ci"
+}`), &cfg)
+	assert.NoError(t, err)
+
+	t.Setenv(api.ProfileFileEnv, filepath.Join(t.TempDir(), "missing.profile"))
+	t.Setenv(api.ProfileEnv, "")
+	profile, err := cfg.ActiveProfile()
+	assert.NoError(t, err)
+	assert.Equal(t, "ci", profile)
+
+	profileFile := filepath.Join(t.TempDir(), "profile")
+	assert.NoError(t, os.WriteFile(profileFile, []byte("personal\n"), 0o600))
+	t.Setenv(api.ProfileFileEnv, profileFile)
+	profile, err = cfg.ActiveProfile()
+	assert.NoError(t, err)
+	assert.Equal(t, "personal", profile)
+
+	t.Setenv(api.ProfileEnv, "ci")
+	profile, err = cfg.ActiveProfile()
+	assert.NoError(t, err)
+	assert.Equal(t, "ci", profile)
+
+	ci, err := cfg.WithProfile("ci")
+	assert.NoError(t, err)
+	helper, _, err := ci.FindHelper("https://github.com/tweag/repo")
+	assert.NoError(t, err)
+	assert.Same(t, registry.HelperFromString("null"), helper)
+	helper, _, err = ci.FindHelper("https://github.com/other/repo")
+	assert.NoError(t, err)
+	assert.Same(t, registry.HelperFromString("github"), helper)
+
+	_, err = cfg.WithProfile("personal")
+	assert.ErrorContains(t, err, `unknown profile "personal"`)
+
+	// invalid regular expressions are reported when the config file is loaded
+	// and errors point to the location in the config file
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	t.Setenv(api.ConfigFileEnv, configFile)
+	for _, tc := range []struct {
+		config string
+		want   string
+	}{
+		{
+			config: `{"urls": [{"host": "github.com", "helper": "github"}, {"host_regex": "(", "helper": "github"}]}`,
+			want:   ".urls[1]: host_regex",
+		},
+		{
+			config: `{"urls": [{"exclude": [{"path_regex": "("}], "helper": "github"}]}`,
+			want:   ".urls[0]: exclude[0]: path_regex",
+		},
+		{
+			config: `{"urls": [{"helper": "github"}], "profiles": {"broken": {"urls": [{"path_regex": "(", "helper": "null"}]}}}`,
+			want:   ".profiles.broken.urls[0]: path_regex",
+		},
+	} {
+		assert.NoError(t, os.WriteFile(configFile, []byte(tc.config), 0o644))
+		_, err = OSReader{}.Read()
+		assert.ErrorContains(t, err, tc.want)
+	}
+}
@@ -0,0 +1,153 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/tweag/credential-helper/agent/locate"
+	"github.com/tweag/credential-helper/api"
+)
+
+// placeholders that are expanded at the start of a string value.
+var placeholders = []string{
+	api.PlaceholderWorkdir,
+	api.PlaceholderWorkspaceDir,
+	api.PlaceholderTmpdir,
+	api.PlaceholderCachedir,
+	api.PlaceholderHomedir,
+}
+
+type interpolationError struct {
+	path string
+	err  error
+}
+
+func (e *interpolationError) Error() string {
+	return fmt.Sprintf("%s: %v", e.path, e.err)
+}
+
+func (e *interpolationError) Unwrap() error {
+	return e.err
+}
+
+// interpolate expands environment variables and path placeholders
+// in every string value of a json document.
+// Object keys are left untouched.
+func interpolate(raw json.RawMessage) (json.RawMessage, error) {
+	if len(raw) == 0 {
+		return raw, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var document any
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+	expanded, err := interpolateValue(document, "")
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(expanded)
+}
+
+func interpolateValue(value any, path string) (any, error) {
+	switch v := value.(type) {
+	case string:
+		expanded, err := interpolateString(v)
+		if err != nil {
+			return nil, &interpolationError{path: path, err: err}
+		}
+		return expanded, nil
+	case []any:
+		for i, elem := range v {
+			expanded, err := interpolateValue(elem, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			v[i] = expanded
+		}
+		return v, nil
+	case map[string]any:
+		// iterate in a stable order to report errors deterministically
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			expanded, err := interpolateValue(v[key], path+"."+key)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = expanded
+		}
+		return v, nil
+	}
+	return value, nil
+}
+
+// interpolateString expands a single string value.
+//
+//   - ${NAME} is replaced by the value of the environment variable NAME. It is an error if NAME is not set.
+//   - ${NAME:-default} is replaced by the value of NAME, or by default if NAME is unset or empty.
+//   - $$ is replaced by a literal $.
+//   - A placeholder (like %workspace% or ~) at the start of the value is replaced by the concrete path,
+//     if it is the whole value or followed by a path separator.
+func interpolateString(input string) (string, error) {
+	var prefix string
+	for _, placeholder := range placeholders {
+		rest, ok := strings.CutPrefix(input, placeholder)
+		if ok && (len(rest) == 0 || rest[0] == '/' || rest[0] == '\\') {
+			prefix = locate.ExpandPath(placeholder)
+			input = rest
+			break
+		}
+	}
+
+	var out strings.Builder
+	out.WriteString(prefix)
+	for i := 0; i < len(input); i++ {
+		if input[i] != '$' || i+1 == len(input) {
+			out.WriteByte(input[i])
+			continue
+		}
+		switch input[i+1] {
+		case '$':
+			out.WriteByte('$')
+			i++
+		case '{':
+			end := strings.IndexByte(input[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated variable reference in %q", input)
+			}
+			value, err := lookupVariable(input[i+2 : i+end])
+			if err != nil {
+				return "", err
+			}
+			out.WriteString(value)
+			i += end
+		default:
+			out.WriteByte('$')
+		}
+	}
+	return out.String(), nil
+}
+
+func lookupVariable(expression string) (string, error) {
+	name, fallback, hasFallback := strings.Cut(expression, ":-")
+	if len(name) == 0 {
+		return "", fmt.Errorf("empty variable name in ${%s}", expression)
+	}
+	value, ok := os.LookupEnv(name)
+	if hasFallback && len(value) == 0 {
+		return fallback, nil
+	}
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set (use ${%s:-default} to provide a default value)", name, name)
+	}
+	return value, nil
+}
@@ -0,0 +1,210 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// URLMatcher decides if a url config applies to a requested url.
+// Every non-empty field must match for the matcher to match.
+type URLMatcher struct {
+	// Scheme must be equal to the scheme of the url.
+	Scheme string `json:"scheme,omitempty"`
+	// Host is a glob pattern for the host of the url, including the port if the url has one.
+	// If Port is set and the pattern contains no port, it is matched against the hostname only.
+	Host string `json:"host,omitempty"`
+	// Port is a glob pattern for the port of the url.
+	// If the url has no explicit port, the default port of the scheme is used (443 for https, 80 for http).
+	Port string `json:"port,omitempty"`
+	// Path is a glob pattern for the path of the url.
+	// If the pattern contains "**", a single "*" no longer matches across "/".
+	Path string `json:"path,omitempty"`
+	// Query maps query parameter names to glob patterns.
+	// Every parameter must be present and at least one of its values must match.
+	Query map[string]string `json:"query,omitempty"`
+	// HostRegex is a regular expression that must match the whole host of the url (including the port, if present).
+	HostRegex string `json:"host_regex,omitempty"`
+	// PathRegex is a regular expression that must match the whole path of the url.
+	PathRegex string `json:"path_regex,omitempty"`
+
+	// compiled holds the regular expressions of the matcher once they are compiled.
+	// It is nil for matchers that were not loaded from a config file.
+	compiled *compiledMatcher
+}
+
+type compiledMatcher struct {
+	// path is the compiled segment-aware glob pattern of Path, if it contains "**".
+	path      *regexp.Regexp
+	hostRegex *regexp.Regexp
+	pathRegex *regexp.Regexp
+}
+
+// compile validates the regular expressions of the matcher
+// and caches the compiled expressions, so that they are not compiled again for every url.
+func (m *URLMatcher) compile() error {
+	var compiled compiledMatcher
+	if strings.Contains(m.Path, "**") {
+		compiled.path = regexp.MustCompile(pathGlobExpr(m.Path))
+	}
+	if len(m.HostRegex) > 0 {
+		re, err := compileAnchored(m.HostRegex)
+		if err != nil {
+			return fmt.Errorf("host_regex: %w", err)
+		}
+		compiled.hostRegex = re
+	}
+	if len(m.PathRegex) > 0 {
+		re, err := compileAnchored(m.PathRegex)
+		if err != nil {
+			return fmt.Errorf("path_regex: %w", err)
+		}
+		compiled.pathRegex = re
+	}
+	m.compiled = &compiled
+	return nil
+}
+
+// compile compiles the matcher and the exclude matchers of the url config.
+func (c *URLConfig) compile() error {
+	if err := c.URLMatcher.compile(); err != nil {
+		return err
+	}
+	for i := range c.Exclude {
+		if err := c.Exclude[i].compile(); err != nil {
+			return fmt.Errorf("exclude[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Matches returns true if the url config applies to the requested url.
+func (c URLConfig) Matches(requested *url.URL) (bool, error) {
+	matches, err := c.URLMatcher.Matches(requested)
+	if err != nil || !matches {
+		return false, err
+	}
+	for i, exclude := range c.Exclude {
+		excluded, err := exclude.Matches(requested)
+		if err != nil {
+			return false, fmt.Errorf("exclude[%d]: %w", i, err)
+		}
+		if excluded {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Matches returns true if every field of the matcher matches the requested url.
+func (m URLMatcher) Matches(requested *url.URL) (bool, error) {
+	if m.compiled == nil {
+		// matchers that are constructed in code are compiled on first use
+		if err := m.compile(); err != nil {
+			return false, err
+		}
+	}
+	// if a scheme is specified, it must match
+	if len(m.Scheme) > 0 && m.Scheme != requested.Scheme {
+		return false, nil
+	}
+	// if a host is specified, it must glob match
+	if len(m.Host) > 0 {
+		host := requested.Host
+		if len(m.Port) > 0 && !strings.Contains(m.Host, ":") {
+			host = requested.Hostname()
+		}
+		if !globMatch(m.Host, host) {
+			return false, nil
+		}
+	}
+	// if a port is specified, it must glob match
+	if len(m.Port) > 0 && !globMatch(m.Port, portOf(requested)) {
+		return false, nil
+	}
+	// if a path is specified, it must glob match
+	if len(m.Path) > 0 {
+		if m.compiled.path != nil {
+			if !m.compiled.path.MatchString(requested.Path) {
+				return false, nil
+			}
+		} else if !globMatch(m.Path, requested.Path) {
+			return false, nil
+		}
+	}
+	if len(m.Query) > 0 {
+		values := requested.Query()
+		for key, pattern := range m.Query {
+			if !anyGlobMatch(pattern, values[key]) {
+				return false, nil
+			}
+		}
+	}
+	if m.compiled.hostRegex != nil && !m.compiled.hostRegex.MatchString(requested.Host) {
+		return false, nil
+	}
+	if m.compiled.pathRegex != nil && !m.compiled.pathRegex.MatchString(requested.Path) {
+		return false, nil
+	}
+	return true, nil
+}
+
+// portOf returns the explicit port of the url,
+// or the default port for well-known schemes.
+func portOf(u *url.URL) string {
+	if port := u.Port(); len(port) > 0 {
+		return port
+	}
+	switch u.Scheme {
+	case "https", "grpcs":
+		return "443"
+	case "http":
+		return "80"
+	}
+	return ""
+}
+
+func anyGlobMatch(pattern string, candidates []string) bool {
+	for _, candidate := range candidates {
+		if globMatch(pattern, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+// compileAnchored compiles a regular expression that must match the whole candidate.
+func compileAnchored(expr string) (*regexp.Regexp, error) {
+	return regexp.Compile(`^(?:` + expr + `)$`)
+}
+
+// pathGlobExpr translates a path glob pattern with "**" into a regular expression.
+// Patterns without "**" use the same semantics as globMatch, where "*" matches any characters.
+// Patterns with "**" are segment-aware: "*" matches within a single path segment
+// and "**" matches any number of segments (including none).
+func pathGlobExpr(pattern string) string {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); {
+		switch {
+		case strings.HasPrefix(pattern[i:], "/**/"):
+			expr.WriteString("/(?:.*/)?")
+			i += 4
+		case strings.HasPrefix(pattern[i:], "/**") && i+3 == len(pattern):
+			expr.WriteString("(?:/.*)?")
+			i += 3
+		case strings.HasPrefix(pattern[i:], "**"):
+			expr.WriteString(".*")
+			i += 2
+		case pattern[i] == '*':
+			expr.WriteString("[^/]*")
+			i++
+		default:
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			i++
+		}
+	}
+	expr.WriteString("$")
+	return expr.String()
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/tweag/credential-helper/agent/locate"
+	"github.com/tweag/credential-helper/api"
+	"github.com/tweag/credential-helper/logging"
+)
+
+// Profile is a named set of url configs.
+// Only the url configs of the active profile are used.
+type Profile struct {
+	URLs []URLConfig `json:"urls,omitempty"`
+}
+
+// ActiveProfile returns the name of the profile selected for this invocation.
+// The profile is chosen by the first of the following that is set:
+//   - the $CREDENTIAL_HELPER_PROFILE environment variable
+//   - the first line of the profile file (%workspace%/.tweag-credential-helper.profile by default)
+//   - the default_profile of the config file
+//
+// An empty name means that no profile is active.
+func (c Config) ActiveProfile() (string, error) {
+	if name, ok := os.LookupEnv(api.ProfileEnv); ok && len(name) > 0 {
+		logging.Debugf("using profile %s from $%s", name, api.ProfileEnv)
+		return name, nil
+	}
+	profilePath := locate.LookupPathEnv(api.ProfileFileEnv, filepath.Join("%workspace%", ".tweag-credential-helper.profile"), false)
+	content, err := os.ReadFile(profilePath)
+	if err == nil {
+		name, _, _ := strings.Cut(string(content), "\n")
+		if name = strings.TrimSpace(name); len(name) > 0 {
+			logging.Debugf("using profile %s from %s", name, profilePath)
+			return name, nil
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("reading profile file: %w", err)
+	}
+	return c.DefaultProfile, nil
+}
+
+// WithProfile returns the config that applies when the named profile is active.
+// The url configs of the profile take precedence over the top-level url configs,
+// which are shared by all profiles.
+// An empty name returns the config unchanged.
+func (c Config) WithProfile(name string) (Config, error) {
+	if len(name) == 0 {
+		return c, nil
+	}
+	profile, ok := c.Profiles[name]
+	if !ok {
+		var known []string
+		for knownName := range c.Profiles {
+			known = append(known, knownName)
+		}
+		slices.Sort(known)
+		return Config{}, fmt.Errorf("invalid configuration file: unknown profile %q. Known profiles are: %s", name, strings.Join(known, ", "))
+	}
+	resolved := c
+	resolved.URLs = append(slices.Clone(profile.URLs), c.URLs...)
+	resolved.Profiles = nil
+	resolved.profile = name
+	resolved.profileURLs = len(profile.URLs)
+	return resolved, nil
+}
@@ -0,0 +1,321 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tweag/credential-helper/agent/locate"
+	"github.com/tweag/credential-helper/logging"
+)
+
+// Values of RewriteConfig.Credentials
+const (
+	RewriteCredentialsMirror   = "mirror"
+	RewriteCredentialsUpstream = "upstream"
+)
+
+// RewriteConfig describes url rewrites performed by Bazel's downloader (--downloader_config).
+// It allows the helper to recognize the upstream url of a request to a mirror.
+type RewriteConfig struct {
+	// DownloaderConfig is the path of a Bazel downloader config file.
+	// The "rewrite", "allow" and "block" directives are used. The path is subject to prefix expansion.
+	DownloaderConfig string `json:"downloader_config,omitempty"`
+	// Rules are additional rewrite rules with the same semantics as the "rewrite" directive.
+	Rules []RewriteRule `json:"rules,omitempty"`
+	// Credentials decides whose credentials are used for a rewritten url:
+	// "upstream" (default) chooses the helper based on the upstream url and passes the upstream url to it,
+	// "mirror" chooses the helper based on the requested (mirror) url.
+	Credentials string `json:"credentials,omitempty"`
+
+	// once guards the parsed downloader config and the compiled rules,
+	// which are loaded on first use and reused for the rest of the process.
+	once     sync.Once
+	compiled compiledRewrites
+	err      error
+}
+
+// compiledRewrites are the rewrite rules and host lists of a RewriteConfig, ready to be applied.
+type compiledRewrites struct {
+	rewrites []compiledRewriteRule
+	allow    []string
+	block    []string
+}
+
+// compiledRewriteRule is a rewrite rule with the regular expressions needed to invert it.
+type compiledRewriteRule struct {
+	RewriteRule
+	// pattern matches the whole url (without the scheme) that is rewritten.
+	pattern *regexp.Regexp
+	// parsedPattern is the syntax tree of the pattern, used to reconstruct the original url.
+	parsedPattern *syntax.Regexp
+	// inverse matches rewritten urls and captures the values of the referenced groups.
+	inverse *regexp.Regexp
+	// groupOrder is the group number of each capture of inverse.
+	groupOrder []int
+}
+
+// RewriteRule rewrites urls matching Pattern to Replacement.
+// Like in Bazel's downloader config, the pattern is matched against the url without the scheme
+// and the replacement can refer to capture groups as $1, $2, ...
+type RewriteRule struct {
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+}
+
+// downloaderConfig is the subset of a Bazel downloader config used by the helper.
+type downloaderConfig struct {
+	rewrites []RewriteRule
+	allow    []string
+	block    []string
+}
+
+// ResolveURI maps a requested uri to the uri used to choose and run a helper.
+// If the requested uri is the result of a rewrite and upstream credentials are configured,
+// the upstream uri is returned.
+// The second return value is true if the downloader config blocks the host of the requested uri.
+func (r *RewriteConfig) ResolveURI(uri string) (string, bool, error) {
+	if r == nil {
+		return uri, false, nil
+	}
+	switch r.Credentials {
+	case "", RewriteCredentialsUpstream, RewriteCredentialsMirror:
+	default:
+		return "", false, fmt.Errorf(`invalid configuration file: unknown rewrite credentials %q. Possible values are "upstream" and "mirror"`, r.Credentials)
+	}
+	r.once.Do(func() {
+		r.compiled, r.err = r.compile()
+	})
+	if r.err != nil {
+		return "", false, r.err
+	}
+	cfg := r.compiled
+
+	requested, err := url.Parse(uri)
+	if err != nil {
+		return "", false, err
+	}
+	if cfg.blocked(requested.Hostname()) {
+		return uri, true, nil
+	}
+	if r.Credentials == RewriteCredentialsMirror {
+		return uri, false, nil
+	}
+	for _, rule := range cfg.rewrites {
+		if upstream, ok := rule.invert(requested); ok {
+			logging.Debugf("mapped rewritten url %s back to upstream url %s", uri, upstream)
+			return upstream, false, nil
+		}
+	}
+	return uri, false, nil
+}
+
+// compile reads the downloader config and compiles all rewrite rules.
+func (r *RewriteConfig) compile() (compiledRewrites, error) {
+	cfg := downloaderConfig{rewrites: r.Rules}
+	if len(r.DownloaderConfig) > 0 {
+		path := locate.RemapToOriginalWorkingDirectory(locate.ExpandPath(r.DownloaderConfig))
+		file, err := os.Open(path)
+		if err != nil {
+			return compiledRewrites{}, fmt.Errorf("reading downloader config: %w", err)
+		}
+		defer file.Close()
+		parsed, err := parseDownloaderConfig(file)
+		if err != nil {
+			return compiledRewrites{}, fmt.Errorf("parsing downloader config %s: %w", path, err)
+		}
+		cfg.rewrites = append(parsed.rewrites, cfg.rewrites...)
+		cfg.allow = parsed.allow
+		cfg.block = parsed.block
+	}
+
+	compiled := compiledRewrites{allow: cfg.allow, block: cfg.block}
+	for _, rule := range cfg.rewrites {
+		compiledRule, err := rule.compile()
+		if err != nil {
+			return compiledRewrites{}, fmt.Errorf("rewrite rule %q: %w", rule.Pattern, err)
+		}
+		compiled.rewrites = append(compiled.rewrites, compiledRule)
+	}
+	return compiled, nil
+}
+
+func (rule RewriteRule) compile() (compiledRewriteRule, error) {
+	pattern, err := regexp.Compile("^(?:" + rule.Pattern + ")$")
+	if err != nil {
+		return compiledRewriteRule{}, err
+	}
+	parsedPattern, err := syntax.Parse(rule.Pattern, syntax.Perl)
+	if err != nil {
+		return compiledRewriteRule{}, err
+	}
+	// Turn the replacement into a pattern that captures the values of the referenced groups.
+	inverse, groupOrder, err := replacementPattern(rule.Replacement)
+	if err != nil {
+		return compiledRewriteRule{}, err
+	}
+	return compiledRewriteRule{
+		RewriteRule:   rule,
+		pattern:       pattern,
+		parsedPattern: parsedPattern,
+		inverse:       inverse,
+		groupOrder:    groupOrder,
+	}, nil
+}
+
+func (c compiledRewrites) blocked(host string) bool {
+	for _, allowed := range c.allow {
+		if strings.EqualFold(allowed, host) {
+			return false
+		}
+	}
+	for _, blocked := range c.block {
+		if blocked == "*" || strings.EqualFold(blocked, host) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseDownloaderConfig(r io.Reader) (downloaderConfig, error) {
+	var cfg downloaderConfig
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "rewrite":
+			if len(fields) != 3 {
+				return cfg, fmt.Errorf("line %d: rewrite expects a pattern and a replacement", lineNumber)
+			}
+			cfg.rewrites = append(cfg.rewrites, RewriteRule{Pattern: fields[1], Replacement: fields[2]})
+		case "allow":
+			if len(fields) != 2 {
+				return cfg, fmt.Errorf("line %d: allow expects a host", lineNumber)
+			}
+			cfg.allow = append(cfg.allow, fields[1])
+		case "block":
+			if len(fields) != 2 {
+				return cfg, fmt.Errorf("line %d: block expects a host", lineNumber)
+			}
+			cfg.block = append(cfg.block, fields[1])
+		default:
+			// other directives (like all_blocked_message) don't affect credentials
+		}
+	}
+	return cfg, scanner.Err()
+}
+
+// invert recovers the url that was rewritten to the requested url.
+// This only works for patterns that consist of literals and capture groups,
+// where every capture group is referenced in the replacement.
+// The result is verified by rewriting it again.
+func (rule compiledRewriteRule) invert(requested *url.URL) (string, bool) {
+	// The replacement either contains a scheme or keeps the scheme of the original url.
+	rewritten := strings.TrimPrefix(requested.String(), requested.Scheme+"://")
+	replacementHasScheme := strings.Contains(rule.Replacement, "://")
+	if replacementHasScheme {
+		rewritten = requested.String()
+	}
+
+	match := rule.inverse.FindStringSubmatch(rewritten)
+	if match == nil {
+		return "", false
+	}
+	groups := make(map[int]string)
+	for i, group := range rule.groupOrder {
+		if previous, ok := groups[group]; ok && previous != match[i+1] {
+			return "", false
+		}
+		groups[group] = match[i+1]
+	}
+
+	original, ok := reconstruct(rule.parsedPattern, groups)
+	if !ok || !rule.pattern.MatchString(original) {
+		return "", false
+	}
+	// verify that Bazel would rewrite the original url to the requested url
+	if rule.pattern.ReplaceAllString(original, expandableReplacement(rule.Replacement)) != rewritten {
+		return "", false
+	}
+
+	scheme := requested.Scheme
+	if replacementHasScheme {
+		// the scheme of the original url is lost, assume https
+		scheme = "https"
+	}
+	return scheme + "://" + original, true
+}
+
+var groupReference = regexp.MustCompile(`\$(\d+|\{\d+\})`)
+
+// replacementPattern converts a replacement like "mirror/$1" into a pattern like "^mirror/(.*)$".
+// It also returns the group number of each capture in the returned pattern.
+func replacementPattern(replacement string) (*regexp.Regexp, []int, error) {
+	var expr strings.Builder
+	var groupOrder []int
+	expr.WriteString("^")
+	last := 0
+	for _, loc := range groupReference.FindAllStringIndex(replacement, -1) {
+		expr.WriteString(regexp.QuoteMeta(replacement[last:loc[0]]))
+		group, err := strconv.Atoi(strings.Trim(replacement[loc[0]+1:loc[1]], "{}"))
+		if err != nil {
+			return nil, nil, err
+		}
+		groupOrder = append(groupOrder, group)
+		expr.WriteString("(.*)")
+		last = loc[1]
+	}
+	expr.WriteString(regexp.QuoteMeta(replacement[last:]))
+	expr.WriteString("$")
+	re, err := regexp.Compile(expr.String())
+	return re, groupOrder, err
+}
+
+// expandableReplacement converts $1 to ${1}, so that Go's regexp package doesn't treat following characters as part of the group name.
+func expandableReplacement(replacement string) string {
+	return groupReference.ReplaceAllStringFunc(replacement, func(ref string) string {
+		return "${" + strings.Trim(ref[1:], "{}") + "}"
+	})
+}
+
+// reconstruct builds a string matched by the pattern, using the given values for capture groups.
+// A single wildcard character is assumed to be an unescaped literal dot (as in "github.com/(.*)").
+func reconstruct(node *syntax.Regexp, groups map[int]string) (string, bool) {
+	switch node.Op {
+	case syntax.OpLiteral:
+		return string(node.Rune), true
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return ".", true
+	case syntax.OpEmptyMatch, syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText, syntax.OpEndText:
+		return "", true
+	case syntax.OpCapture:
+		if value, ok := groups[node.Cap]; ok {
+			return value, true
+		}
+		return reconstruct(node.Sub[0], groups)
+	case syntax.OpConcat:
+		var out strings.Builder
+		for _, sub := range node.Sub {
+			part, ok := reconstruct(sub, groups)
+			if !ok {
+				return "", false
+			}
+			out.WriteString(part)
+		}
+		return out.String(), true
+	}
+	return "", false
+}
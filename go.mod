@@ -3,6 +3,7 @@ module github.com/tweag/credential-helper
 go 1.24.2
 
 require (
+	filippo.io/age v1.2.1
 	github.com/Azure/azure-sdk-for-go/sdk/azcore v1.17.0
 	github.com/Azure/azure-sdk-for-go/sdk/azidentity v1.8.2
 	github.com/aws/aws-sdk-go-v2 v1.36.3
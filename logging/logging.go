@@ -17,6 +17,14 @@ const (
 
 var level = LogLevelOff
 
+// syslogDisabled prevents writes to the syslog.
+var syslogDisabled bool
+
+// DisableSyslog turns the syslog functions into their stderr counterparts.
+func DisableSyslog() {
+	syslogDisabled = true
+}
+
 func SetLevel(l LogLevel) {
 	level = l
 }
@@ -7,3 +7,8 @@ package logging
 func SyslogDebugf(format string, args ...any) {
 	Debugf(format, args...)
 }
+
+// SyslogWarnf prints a warning to stderr, independent of the log level.
+func SyslogWarnf(format string, args ...any) {
+	Errorf(format, args...)
+}
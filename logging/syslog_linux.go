@@ -23,8 +23,28 @@ func SyslogDebugf(format string, args ...any) {
 	if level < LogLevelDebug {
 		return
 	}
+	if syslogDisabled {
+		Debugf(format, args...)
+		return
+	}
 	if syslogger == nil {
 		Init()
 	}
 	syslogger.Debug(fmt.Sprintf(format, args...))
 }
+
+// SyslogWarnf writes a warning to the syslog, independent of the log level.
+// It also prints the warning to stderr.
+func SyslogWarnf(format string, args ...any) {
+	Errorf(format, args...)
+	if syslogDisabled {
+		return
+	}
+	if syslogger == nil {
+		Init()
+	}
+	if syslogger == nil {
+		return
+	}
+	_ = syslogger.Warning(fmt.Sprintf(format, args...))
+}
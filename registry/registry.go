@@ -3,10 +3,14 @@ package registry
 import (
 	"github.com/tweag/credential-helper/api"
 	authenticateAzStorage "github.com/tweag/credential-helper/authenticate/azstorage"
+	authenticateComposite "github.com/tweag/credential-helper/authenticate/composite"
 	authenticateGAR "github.com/tweag/credential-helper/authenticate/gar"
 	authenticateGCS "github.com/tweag/credential-helper/authenticate/gcs"
 	authenticateGitHub "github.com/tweag/credential-helper/authenticate/github"
+	authenticateHeader "github.com/tweag/credential-helper/authenticate/header"
+	authenticateNetrc "github.com/tweag/credential-helper/authenticate/netrc"
 	authenticateNull "github.com/tweag/credential-helper/authenticate/null"
+	authenticateOAuth2 "github.com/tweag/credential-helper/authenticate/oauth2"
 	authenticateOCI "github.com/tweag/credential-helper/authenticate/oci"
 	authenticateRemoteAPIs "github.com/tweag/credential-helper/authenticate/remoteapis"
 	authenticateS3 "github.com/tweag/credential-helper/authenticate/s3"
@@ -18,13 +22,22 @@ var singleton = Helpers{
 		"gar":        &authenticateGAR.GAR{},
 		"azstorage":  &authenticateAzStorage.AzStorage{},
 		"github":     &authenticateGitHub.GitHub{},
+		"header":     &authenticateHeader.Header{},
+		"netrc":      &authenticateNetrc.Netrc{},
 		"null":       &authenticateNull.Null{},
+		"oauth2":     &authenticateOAuth2.OAuth2{},
 		"oci":        authenticateOCI.NewFallbackOCI(),
 		"remoteapis": &authenticateRemoteAPIs.RemoteAPIs{},
 		"s3":         &authenticateS3.S3{},
 	},
 }
 
+func init() {
+	// The composite helper looks up its children in the registry.
+	// It is registered here to avoid an initialization cycle.
+	singleton.register("composite", authenticateComposite.New(HelperFromString))
+}
+
 // HelperFromString returns the helper corresponding to the given string.
 func HelperFromString(s string) api.Helper {
 	return singleton.Map[s]
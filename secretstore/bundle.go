@@ -0,0 +1,301 @@
+package secretstore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/tweag/credential-helper/agent/locate"
+	"github.com/tweag/credential-helper/api"
+)
+
+const bundleFormatVersion = 1
+
+// BundleIdentityService is the default name of the bundle identity in the secret store.
+const BundleIdentityService = "tweag-credential-helper:bundle-identity"
+
+// DefaultBundlePath is the default path of the encrypted bundle in the workspace.
+// It is subject to prefix expansion.
+const DefaultBundlePath = "%workspace%/.tweag-credential-helper.bundle"
+
+// Bundle is a file of named secrets that can be committed to a repository.
+// The file is an armored age file encrypted for all recipients (X25519),
+// so it can also be decrypted using the age command line tools.
+// The plaintext is the JSON encoding of the bundle, including the list of recipients.
+// The format is specified in docs/lookup_chain.md.
+type Bundle struct {
+	Version int `json:"version"`
+	// Recipients are the age recipients (age1...) the bundle is encrypted for.
+	Recipients []string `json:"recipients"`
+	// Entries maps names to secrets.
+	Entries map[string]string `json:"entries"`
+}
+
+// Identity is the age X25519 identity of a bundle recipient.
+type Identity struct {
+	identity *age.X25519Identity
+}
+
+// GenerateIdentity creates a new random identity.
+func GenerateIdentity() (*Identity, error) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{identity: identity}, nil
+}
+
+// ParseIdentity decodes an identity (AGE-SECRET-KEY-1...).
+// Empty lines and comments are ignored, so files created by age-keygen can be used as well.
+func ParseIdentity(encoded string) (*Identity, error) {
+	identities, err := age.ParseIdentities(strings.NewReader(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("invalid bundle identity: %w", err)
+	}
+	for _, identity := range identities {
+		if x25519, ok := identity.(*age.X25519Identity); ok {
+			return &Identity{identity: x25519}, nil
+		}
+	}
+	return nil, errors.New("invalid bundle identity: no X25519 identity found")
+}
+
+// String encodes the identity. The result is a secret.
+func (i *Identity) String() string {
+	return i.identity.String()
+}
+
+// Recipient returns the age recipient of the identity (age1...), which can be shared.
+func (i *Identity) Recipient() string {
+	return i.identity.Recipient().String()
+}
+
+// IdentitySources describes where to look for a bundle identity.
+// Empty fields are skipped.
+type IdentitySources struct {
+	// Env is the name of an environment variable holding the identity.
+	Env string
+	// File is the path of a file holding the identity. It is subject to prefix expansion.
+	File string
+	// Service is the name of the identity in the secret store ("auto" store).
+	Service string
+}
+
+// DefaultIdentitySources returns the default locations of the bundle identity:
+// $CREDENTIAL_HELPER_BUNDLE_IDENTITY, a file in the user config directory and the secret store.
+func DefaultIdentitySources() IdentitySources {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		configDir = filepath.Join(locate.ExpandPath("~"), ".config")
+	}
+	return IdentitySources{
+		Env:     api.BundleIdentityEnv,
+		File:    filepath.Join(configDir, "tweag-credential-helper", "bundle-identity"),
+		Service: BundleIdentityService,
+	}
+}
+
+// Find returns the first identity found in the environment, the file or the secret store.
+// It returns ErrNotFound if none of them holds an identity.
+func (s IdentitySources) Find() (*Identity, error) {
+	if len(s.Env) > 0 {
+		if encoded := os.Getenv(s.Env); len(encoded) > 0 {
+			return ParseIdentity(encoded)
+		}
+	}
+	if len(s.File) > 0 {
+		encoded, err := os.ReadFile(locate.ExpandPath(s.File))
+		if err == nil {
+			return ParseIdentity(string(encoded))
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("reading bundle identity: %w", err)
+		}
+	}
+	if len(s.Service) > 0 {
+		store, err := Open(StoreAuto)
+		if err != nil {
+			return nil, err
+		}
+		encoded, err := store.Get(s.Service)
+		if err == nil {
+			return ParseIdentity(encoded)
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return nil, fmt.Errorf("reading bundle identity from %s: %w", store.Name(), err)
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// NewBundle returns an empty bundle for the recipients.
+func NewBundle(recipients []string) (*Bundle, error) {
+	bundle := &Bundle{Version: bundleFormatVersion, Entries: make(map[string]string)}
+	for _, recipient := range recipients {
+		if err := bundle.AddRecipient(recipient); err != nil {
+			return nil, err
+		}
+	}
+	if len(bundle.Recipients) == 0 {
+		return nil, errors.New("a bundle needs at least one recipient")
+	}
+	return bundle, nil
+}
+
+// ReadBundle decrypts a bundle file using the identity.
+// Both armored and binary age files are accepted.
+// Errors opening the file are returned unwrapped, so callers can check for fs.ErrNotExist.
+func ReadBundle(path string, identity *Identity) (*Bundle, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var src io.Reader = bufio.NewReader(file)
+	if start, _ := src.(*bufio.Reader).Peek(len(armor.Header)); string(start) == armor.Header {
+		src = armor.NewReader(src)
+	}
+	plaintext, err := age.Decrypt(src, identity.identity)
+	var noMatch *age.NoIdentityMatchError
+	if errors.As(err, &noMatch) {
+		return nil, fmt.Errorf("identity %s is not a recipient of the bundle %s", identity.Recipient(), path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decrypting bundle %s: %w", path, err)
+	}
+	raw, err := io.ReadAll(plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting bundle %s: %w", path, err)
+	}
+	var bundle Bundle
+	if err := json.Unmarshal(raw, &bundle); err != nil {
+		return nil, fmt.Errorf("parsing bundle %s: %w", path, err)
+	}
+	if bundle.Version != bundleFormatVersion {
+		return nil, fmt.Errorf("unsupported version %d of bundle %s", bundle.Version, path)
+	}
+	if bundle.Entries == nil {
+		bundle.Entries = make(map[string]string)
+	}
+	return &bundle, nil
+}
+
+// WriteFile encrypts the bundle for its recipients and atomically writes it to a file.
+// Every write uses a new file key, so removed recipients cannot decrypt the new file.
+func (b *Bundle) WriteFile(path string) error {
+	if len(b.Recipients) == 0 {
+		return errors.New("a bundle needs at least one recipient")
+	}
+	recipients := make([]age.Recipient, len(b.Recipients))
+	for i, encoded := range b.Recipients {
+		recipient, err := parseRecipient(encoded)
+		if err != nil {
+			return err
+		}
+		recipients[i] = recipient
+	}
+	plaintext, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	var encrypted bytes.Buffer
+	armorWriter := armor.NewWriter(&encrypted)
+	ageWriter, err := age.Encrypt(armorWriter, recipients...)
+	if err != nil {
+		return err
+	}
+	if _, err := ageWriter.Write(plaintext); err != nil {
+		return err
+	}
+	if err := ageWriter.Close(); err != nil {
+		return err
+	}
+	if err := armorWriter.Close(); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".bundle-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(encrypted.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Get returns the entry with the given name.
+// It returns ErrNotFound if the bundle has no such entry.
+func (b *Bundle) Get(name string) (string, error) {
+	value, ok := b.Entries[name]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+// Set stores a value under the given name, replacing an existing entry.
+func (b *Bundle) Set(name, value string) {
+	b.Entries[name] = value
+}
+
+// Delete removes an entry. It returns ErrNotFound if the bundle has no such entry.
+func (b *Bundle) Delete(name string) error {
+	if _, ok := b.Entries[name]; !ok {
+		return ErrNotFound
+	}
+	delete(b.Entries, name)
+	return nil
+}
+
+// AddRecipient gives a recipient access to all entries.
+func (b *Bundle) AddRecipient(recipient string) error {
+	recipient = strings.TrimSpace(recipient)
+	if _, err := parseRecipient(recipient); err != nil {
+		return err
+	}
+	if slices.Contains(b.Recipients, recipient) {
+		return fmt.Errorf("%s is already a recipient", recipient)
+	}
+	b.Recipients = append(b.Recipients, recipient)
+	return nil
+}
+
+// RemoveRecipient revokes access of a recipient to future versions of the bundle.
+func (b *Bundle) RemoveRecipient(recipient string) error {
+	recipient = strings.TrimSpace(recipient)
+	if !slices.Contains(b.Recipients, recipient) {
+		return fmt.Errorf("%s is not a recipient", recipient)
+	}
+	if len(b.Recipients) == 1 {
+		return errors.New("cannot remove the last recipient of the bundle")
+	}
+	b.Recipients = slices.DeleteFunc(b.Recipients, func(r string) bool { return r == recipient })
+	return nil
+}
+
+func parseRecipient(encoded string) (*age.X25519Recipient, error) {
+	recipient, err := age.ParseX25519Recipient(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("invalid bundle recipient %q: %w", encoded, err)
+	}
+	return recipient, nil
+}
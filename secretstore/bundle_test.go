@@ -0,0 +1,164 @@
+package secretstore
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBundle(t *testing.T) {
+	alice, err := GenerateIdentity()
+	assert.NoError(t, err)
+	bob, err := GenerateIdentity()
+	assert.NoError(t, err)
+	parsed, err := ParseIdentity("# created by age-keygen\n" + alice.String() + "\n")
+	assert.NoError(t, err)
+	assert.Equal(t, alice.Recipient(), parsed.Recipient())
+	assert.True(t, strings.HasPrefix(alice.Recipient(), "age1"))
+
+	bundle, err := NewBundle([]string{alice.Recipient()})
+	assert.NoError(t, err)
+	bundle.Set("token", "s3cret")
+	bundle.Set("other", "value")
+
+	path := filepath.Join(t.TempDir(), "bundle")
+	assert.NoError(t, bundle.WriteFile(path))
+	raw, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(raw), armor.Header))
+	assert.NotContains(t, string(raw), "s3cret")
+	assert.NotContains(t, string(raw), "token")
+
+	bundle, err = ReadBundle(path, alice)
+	assert.NoError(t, err)
+	value, err := bundle.Get("token")
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cret", value)
+	_, err = bundle.Get("missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = ReadBundle(path, bob)
+	assert.ErrorContains(t, err, "not a recipient")
+	_, err = ReadBundle(filepath.Join(t.TempDir(), "missing"), alice)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	assert.NoError(t, bundle.AddRecipient(bob.Recipient()))
+	assert.ErrorContains(t, bundle.AddRecipient(bob.Recipient()), "already a recipient")
+	assert.ErrorContains(t, bundle.AddRecipient("tch-recipient1-invalid"), "invalid bundle recipient")
+	assert.NoError(t, bundle.WriteFile(path))
+	bundle, err = ReadBundle(path, bob)
+	assert.NoError(t, err)
+	value, err = bundle.Get("other")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", value)
+
+	assert.NoError(t, bundle.RemoveRecipient(bob.Recipient()))
+	assert.ErrorContains(t, bundle.RemoveRecipient(alice.Recipient()), "last recipient")
+	assert.NoError(t, bundle.WriteFile(path))
+	_, err = ReadBundle(path, bob)
+	assert.ErrorContains(t, err, "not a recipient")
+	bundle, err = ReadBundle(path, alice)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{alice.Recipient()}, bundle.Recipients)
+}
+
+// Bundles are regular age files, so they can be decrypted using age tools.
+func TestBundleAgeCompatibility(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	assert.NoError(t, err)
+	bundle, err := NewBundle([]string{identity.Recipient().String()})
+	assert.NoError(t, err)
+	bundle.Set("token", "s3cret")
+	path := filepath.Join(t.TempDir(), "bundle")
+	assert.NoError(t, bundle.WriteFile(path))
+
+	file, err := os.Open(path)
+	assert.NoError(t, err)
+	defer file.Close()
+	plaintext, err := age.Decrypt(armor.NewReader(file), identity)
+	assert.NoError(t, err)
+	raw, err := io.ReadAll(plaintext)
+	assert.NoError(t, err)
+	assert.JSONEq(t, fmt.Sprintf(`{"version": 1, "recipients": [%q], "entries": {"token": "s3cret"}}`, identity.Recipient()), string(raw))
+
+	// binary (not armored) age files are accepted as well
+	binaryPath := filepath.Join(t.TempDir(), "bundle.age")
+	binary, err := os.Create(binaryPath)
+	assert.NoError(t, err)
+	writer, err := age.Encrypt(binary, identity.Recipient())
+	assert.NoError(t, err)
+	_, err = writer.Write(raw)
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+	assert.NoError(t, binary.Close())
+	parsed, err := ParseIdentity(identity.String())
+	assert.NoError(t, err)
+	bundle, err = ReadBundle(binaryPath, parsed)
+	assert.NoError(t, err)
+	value, err := bundle.Get("token")
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cret", value)
+}
+
+func TestBundleTampering(t *testing.T) {
+	identity, err := GenerateIdentity()
+	assert.NoError(t, err)
+	bundle, err := NewBundle([]string{identity.Recipient()})
+	assert.NoError(t, err)
+	bundle.Set("token", "s3cret")
+	path := filepath.Join(t.TempDir(), "bundle")
+	assert.NoError(t, bundle.WriteFile(path))
+	raw, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	lines := strings.Split(string(raw), "\n")
+
+	tests := []struct {
+		name   string
+		tamper func(lines []string)
+	}{
+		{
+			name: "header",
+			tamper: func(lines []string) {
+				lines[1] = flipBase64(lines[1], 10)
+			},
+		},
+		{
+			name: "payload",
+			tamper: func(lines []string) {
+				// the line before the footer is part of the payload
+				lines[len(lines)-3] = flipBase64(lines[len(lines)-3], 0)
+			},
+		},
+		{
+			name: "truncated",
+			tamper: func(lines []string) {
+				copy(lines[len(lines)-3:], []string{lines[len(lines)-2], "", ""})
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := append([]string(nil), lines...)
+			tt.tamper(tampered)
+			tamperedPath := filepath.Join(t.TempDir(), "bundle")
+			assert.NoError(t, os.WriteFile(tamperedPath, []byte(strings.Join(tampered, "\n")), 0o644))
+			_, err := ReadBundle(tamperedPath, identity)
+			assert.Error(t, err)
+		})
+	}
+}
+
+// flipBase64 replaces the base64 character at index with a different one.
+func flipBase64(line string, index int) string {
+	replacement := byte('A')
+	if line[index] == 'A' {
+		replacement = 'B'
+	}
+	return line[:index] + string(replacement) + line[index+1:]
+}
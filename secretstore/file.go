@@ -0,0 +1,303 @@
+package secretstore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/tweag/credential-helper/agent/locate"
+	"github.com/tweag/credential-helper/api"
+)
+
+const (
+	fileFormatVersion = 1
+	// pbkdf2Iterations follows the OWASP recommendation for PBKDF2-HMAC-SHA256.
+	pbkdf2Iterations = 600_000
+	keyLength        = 32
+)
+
+// Key derivation functions of the encrypted file
+const (
+	// kdfPBKDF2 stretches a passphrase. Files without a kdf use it as well.
+	kdfPBKDF2 = "pbkdf2-sha256"
+	// kdfHKDF derives the key from a random key file, which needs no stretching.
+	kdfHKDF = "hkdf-sha256"
+)
+
+// File is a secret store in a file encrypted with AES-256-GCM.
+// The key is derived from a passphrase ($CREDENTIAL_HELPER_SECRET_STORE_PASSPHRASE) using PBKDF2
+// or from the content of a random key file using HKDF. The key file is created on first use.
+// A key file next to the encrypted file only obfuscates the secrets:
+// it does not protect them against a local attacker who can read both files.
+type File struct {
+	// Path is the path of the encrypted file.
+	Path string
+	// KeyFile is the path of the key file, used if no passphrase is set.
+	KeyFile string
+}
+
+// encryptedFile is the on-disk format of the store.
+type encryptedFile struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf,omitempty"`
+	Iterations int    `json:"iterations,omitempty"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// fileCache keeps derived keys and decrypted stores for the lifetime of the process,
+// since deriving a key from a passphrase is deliberately slow and a helper may read many secrets.
+// A decrypted store is reused as long as the file content and the key material are unchanged.
+var fileCache = struct {
+	sync.Mutex
+	aeads    map[aeadKey]cipher.AEAD
+	contents map[string]cachedContent
+}{
+	aeads:    make(map[aeadKey]cipher.AEAD),
+	contents: make(map[string]cachedContent),
+}
+
+type aeadKey struct {
+	kdf        string
+	salt       string
+	iterations int
+	secret     [sha256.Size]byte
+}
+
+type cachedContent struct {
+	raw     []byte
+	secret  [sha256.Size]byte
+	secrets map[string]string
+}
+
+// DefaultFile returns the encrypted file store in the user config directory.
+// The locations can be changed using $CREDENTIAL_HELPER_SECRET_STORE_FILE and $CREDENTIAL_HELPER_SECRET_STORE_KEY_FILE.
+func DefaultFile() *File {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		configDir = filepath.Join(locate.ExpandPath("~"), ".config")
+	}
+	dir := filepath.Join(configDir, "tweag-credential-helper")
+	return &File{
+		Path:    locate.LookupPathEnv(api.SecretStoreFileEnv, filepath.Join(dir, "secrets.enc"), false),
+		KeyFile: locate.LookupPathEnv(api.SecretStoreKeyFileEnv, filepath.Join(dir, "secrets.key"), false),
+	}
+}
+
+func (f *File) Get(service string) (string, error) {
+	secrets, err := f.read()
+	if err != nil {
+		return "", err
+	}
+	secret, ok := secrets[service]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return secret, nil
+}
+
+func (f *File) Set(service, secret string) error {
+	secrets, err := f.read()
+	if errors.Is(err, ErrNotFound) {
+		secrets = make(map[string]string)
+	} else if err != nil {
+		return err
+	}
+	secrets[service] = secret
+	return f.write(secrets)
+}
+
+func (f *File) Delete(service string) error {
+	secrets, err := f.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := secrets[service]; !ok {
+		return ErrNotFound
+	}
+	delete(secrets, service)
+	return f.write(secrets)
+}
+
+func (f *File) Name() string {
+	return "encrypted file " + f.Path
+}
+
+// read decrypts the store. It returns ErrNotFound if the file doesn't exist.
+func (f *File) read() (map[string]string, error) {
+	raw, err := os.ReadFile(f.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	// the file records the key derivation function, since older files used PBKDF2 for key files as well
+	secret, _, err := f.keyMaterial(false)
+	if err != nil {
+		return nil, err
+	}
+	secretHash := sha256.Sum256([]byte(secret))
+
+	fileCache.Lock()
+	defer fileCache.Unlock()
+	if cached, ok := fileCache.contents[f.Path]; ok && cached.secret == secretHash && bytes.Equal(cached.raw, raw) {
+		return maps.Clone(cached.secrets), nil
+	}
+
+	var encrypted encryptedFile
+	if err := json.Unmarshal(raw, &encrypted); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", f.Path, err)
+	}
+	if encrypted.Version != fileFormatVersion {
+		return nil, fmt.Errorf("unsupported version %d of %s", encrypted.Version, f.Path)
+	}
+	if len(encrypted.KDF) == 0 {
+		encrypted.KDF = kdfPBKDF2
+	}
+	aead, err := deriveAEAD(encrypted.KDF, secret, encrypted.Salt, encrypted.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, encrypted.Nonce, encrypted.Ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting %s (wrong passphrase or key file?): %w", f.Path, err)
+	}
+	var secrets map[string]string
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, fmt.Errorf("parsing decrypted secrets: %w", err)
+	}
+	fileCache.contents[f.Path] = cachedContent{raw: raw, secret: secretHash, secrets: secrets}
+	return maps.Clone(secrets), nil
+}
+
+// write encrypts the store with a fresh salt and nonce and atomically replaces the file.
+func (f *File) write(secrets map[string]string) error {
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	secret, kdf, err := f.keyMaterial(true)
+	if err != nil {
+		return err
+	}
+	encrypted := encryptedFile{
+		Version: fileFormatVersion,
+		KDF:     kdf,
+		Salt:    make([]byte, 16),
+	}
+	if kdf == kdfPBKDF2 {
+		encrypted.Iterations = pbkdf2Iterations
+	}
+	if _, err := rand.Read(encrypted.Salt); err != nil {
+		return err
+	}
+
+	fileCache.Lock()
+	defer fileCache.Unlock()
+	aead, err := deriveAEAD(encrypted.KDF, secret, encrypted.Salt, encrypted.Iterations)
+	if err != nil {
+		return err
+	}
+	encrypted.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(encrypted.Nonce); err != nil {
+		return err
+	}
+	encrypted.Ciphertext = aead.Seal(nil, encrypted.Nonce, plaintext, nil)
+	raw, err := json.Marshal(encrypted)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.Path), ".secrets-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), f.Path); err != nil {
+		return err
+	}
+	fileCache.contents[f.Path] = cachedContent{raw: raw, secret: sha256.Sum256([]byte(secret)), secrets: maps.Clone(secrets)}
+	return nil
+}
+
+// deriveAEAD returns the cipher for the key material and salt.
+// Derived ciphers are cached in fileCache, which must be locked by the caller.
+func deriveAEAD(kdf, secret string, salt []byte, iterations int) (cipher.AEAD, error) {
+	cacheKey := aeadKey{kdf: kdf, salt: string(salt), iterations: iterations, secret: sha256.Sum256([]byte(secret))}
+	if aead, ok := fileCache.aeads[cacheKey]; ok {
+		return aead, nil
+	}
+	var key []byte
+	var err error
+	switch kdf {
+	case kdfPBKDF2:
+		key, err = pbkdf2.Key(sha256.New, secret, salt, iterations, keyLength)
+	case kdfHKDF:
+		key, err = hkdf.Key(sha256.New, []byte(secret), salt, "tweag-credential-helper secret store", keyLength)
+	default:
+		err = fmt.Errorf("unsupported key derivation function %q", kdf)
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	fileCache.aeads[cacheKey] = aead
+	return aead, nil
+}
+
+// keyMaterial returns the passphrase or the content of the key file, together with the matching key derivation function.
+// If neither exists and createKeyFile is true, a random key file is created.
+func (f *File) keyMaterial(createKeyFile bool) (string, string, error) {
+	if passphrase, ok := os.LookupEnv(api.SecretStorePassphraseEnv); ok && len(passphrase) > 0 {
+		return passphrase, kdfPBKDF2, nil
+	}
+	key, err := os.ReadFile(f.KeyFile)
+	if err == nil {
+		return string(key), kdfHKDF, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return "", "", fmt.Errorf("reading key file: %w", err)
+	}
+	if !createKeyFile {
+		return "", "", fmt.Errorf("no passphrase in $%s and no key file at %s", api.SecretStorePassphraseEnv, f.KeyFile)
+	}
+	if err := os.MkdirAll(filepath.Dir(f.KeyFile), 0o700); err != nil {
+		return "", "", err
+	}
+	key = []byte(rand.Text())
+	if err := os.WriteFile(f.KeyFile, key, 0o600); err != nil {
+		return "", "", fmt.Errorf("creating key file: %w", err)
+	}
+	return string(key), kdfHKDF, nil
+}
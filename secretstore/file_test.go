@@ -0,0 +1,105 @@
+package secretstore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tweag/credential-helper/api"
+)
+
+func TestFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(api.SecretStorePassphraseEnv, "")
+	store := &File{
+		Path:    filepath.Join(dir, "secrets.enc"),
+		KeyFile: filepath.Join(dir, "secrets.key"),
+	}
+
+	_, err := store.Get("gh:github.com")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.NoError(t, store.Set("gh:github.com", "ghp_secret"))
+	assert.NoError(t, store.Set("other", "value"))
+	secret, err := store.Get("gh:github.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "ghp_secret", secret)
+
+	// the secret is not stored in plain text and the key file is private
+	raw, err := os.ReadFile(store.Path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(raw), "ghp_secret")
+	info, err := os.Stat(store.KeyFile)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	// a passphrase takes precedence over the key file
+	t.Setenv(api.SecretStorePassphraseEnv, "wrong")
+	_, err = store.Get("gh:github.com")
+	assert.ErrorContains(t, err, "wrong passphrase")
+	t.Setenv(api.SecretStorePassphraseEnv, "")
+
+	assert.NoError(t, store.Delete("gh:github.com"))
+	assert.ErrorIs(t, store.Delete("gh:github.com"), ErrNotFound)
+	secret, err = store.Get("other")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", secret)
+}
+
+func TestFileKeyDerivation(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(api.SecretStorePassphraseEnv, "")
+	store := &File{
+		Path:    filepath.Join(dir, "secrets.enc"),
+		KeyFile: filepath.Join(dir, "secrets.key"),
+	}
+
+	// a random key file needs no key stretching
+	assert.NoError(t, store.Set("service", "secret"))
+	assert.Equal(t, kdfHKDF, readEncryptedFile(t, store.Path).KDF)
+
+	// a passphrase is stretched with PBKDF2
+	t.Setenv(api.SecretStorePassphraseEnv, "passphrase")
+	passphraseStore := &File{Path: filepath.Join(dir, "passphrase.enc"), KeyFile: store.KeyFile}
+	assert.NoError(t, passphraseStore.Set("service", "secret"))
+	encrypted := readEncryptedFile(t, passphraseStore.Path)
+	assert.Equal(t, kdfPBKDF2, encrypted.KDF)
+	assert.Equal(t, pbkdf2Iterations, encrypted.Iterations)
+	t.Setenv(api.SecretStorePassphraseEnv, "")
+
+	// files without a kdf were encrypted using PBKDF2, even with a key file
+	key, err := os.ReadFile(store.KeyFile)
+	assert.NoError(t, err)
+	legacy := encryptedFile{Version: fileFormatVersion, Iterations: 1000, Salt: []byte("0123456789abcdef")}
+	derived, err := pbkdf2.Key(sha256.New, string(key), legacy.Salt, legacy.Iterations, keyLength)
+	assert.NoError(t, err)
+	block, err := aes.NewCipher(derived)
+	assert.NoError(t, err)
+	aead, err := cipher.NewGCM(block)
+	assert.NoError(t, err)
+	legacy.Nonce = make([]byte, aead.NonceSize())
+	legacy.Ciphertext = aead.Seal(nil, legacy.Nonce, []byte(`{"service":"legacy"}`), nil)
+	raw, err := json.Marshal(legacy)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(store.Path, raw, 0o600))
+
+	// the cached content is not used after the file changed
+	secret, err := store.Get("service")
+	assert.NoError(t, err)
+	assert.Equal(t, "legacy", secret)
+}
+
+func readEncryptedFile(t *testing.T, path string) encryptedFile {
+	t.Helper()
+	raw, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	var encrypted encryptedFile
+	assert.NoError(t, json.Unmarshal(raw, &encrypted))
+	return encrypted
+}
@@ -0,0 +1,86 @@
+// Package secretstore stores secrets by service name,
+// either in the system keyring or in an encrypted file.
+package secretstore
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	keyring "github.com/zalando/go-keyring"
+)
+
+// Names of the stores
+const (
+	StoreSystem = "system"
+	StoreFile   = "file"
+	StoreAuto   = "auto"
+)
+
+// ErrNotFound is returned if no secret is stored for a service.
+var ErrNotFound = errors.New("secret not found")
+
+// Store stores secrets by service name.
+type Store interface {
+	Get(service string) (string, error)
+	Set(service, secret string) error
+	Delete(service string) error
+	// Name returns a human-readable description of the store.
+	Name() string
+}
+
+// System is the system keyring (like the macOS keychain, the Windows credential manager or the D-Bus Secret Service).
+type System struct{}
+
+func (System) Get(service string) (string, error) {
+	secret, err := keyring.Get(service, "")
+	if errors.Is(err, keyring.ErrNotFound) {
+		return "", ErrNotFound
+	}
+	return secret, err
+}
+
+func (System) Set(service, secret string) error {
+	return keyring.Set(service, "", secret)
+}
+
+func (System) Delete(service string) error {
+	err := keyring.Delete(service, "")
+	if errors.Is(err, keyring.ErrNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (System) Name() string {
+	return "system keyring"
+}
+
+// SystemAvailable returns true if the system keyring can be used.
+// On headless Linux machines (build servers, containers, ssh sessions), there is often no D-Bus Secret Service.
+// The keyring is probed once per process.
+func SystemAvailable() bool {
+	return systemAvailable()
+}
+
+var systemAvailable = sync.OnceValue(func() bool {
+	_, err := keyring.Get("tweag-credential-helper:probe", "")
+	return err == nil || errors.Is(err, keyring.ErrNotFound)
+})
+
+// Open returns the store with the given name.
+// The "auto" store is the system keyring if it is available, and the encrypted file otherwise.
+func Open(name string) (Store, error) {
+	switch name {
+	case StoreSystem:
+		return System{}, nil
+	case StoreFile:
+		return DefaultFile(), nil
+	case "", StoreAuto:
+		if SystemAvailable() {
+			return System{}, nil
+		}
+		return DefaultFile(), nil
+	}
+	return nil, fmt.Errorf(`unknown secret store %q. Possible values are "auto", "system" and "file"`, name)
+}